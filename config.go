@@ -0,0 +1,529 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every user-tunable setting. It is assembled in layers, each
+// overriding the fields it sets: built-in defaults, the user-global file,
+// the project-local file, an explicit -config file, environment variables
+// and finally command-line flags.
+type Config struct {
+	Provider      ProviderConfig   `yaml:"provider"`
+	Model         string           `yaml:"model"`
+	FallbackModel string           `yaml:"fallback_model,omitempty"`
+	Vision        *bool            `yaml:"vision,omitempty"` // whether the model accepts images; guessed from its name when unset
+	Temperature   *float32         `yaml:"temperature,omitempty"`
+	MaxTokens     int              `yaml:"max_tokens"`
+	Tools         ToolsConfig      `yaml:"tools"`
+	Timeouts      TimeoutsConfig   `yaml:"timeouts"`
+	Retry         RetryConfig      `yaml:"retry"`
+	Loop          LoopConfig       `yaml:"loop"`
+	Permissions   PermissionConfig `yaml:"permissions"`
+	PlanMode      bool             `yaml:"plan_mode"`
+	Output        OutputConfig     `yaml:"output"`
+	Web           WebConfig        `yaml:"web"`
+	// PostWrite formats and lints files after tools write them.
+	PostWrite []PostWriteHook `yaml:"post_write,omitempty"`
+	Hooks     HooksConfig     `yaml:"hooks,omitempty"`
+
+	MCPServers map[string]MCPServerConfig `yaml:"mcp_servers,omitempty"`
+	LSPServers map[string]LSPServerConfig `yaml:"lsp_servers,omitempty"`
+
+	// Sources lists the config files that were found and applied, in order.
+	Sources []string `yaml:"-"`
+}
+
+type ProviderConfig struct {
+	Name      string `yaml:"name"`
+	BaseURL   string `yaml:"base_url"`
+	APIKeyEnv string `yaml:"api_key_env"`
+}
+
+type ToolsConfig struct {
+	// Enabled restricts the registered tools to this list when non-empty.
+	Enabled []string `yaml:"enabled,omitempty"`
+	// Disabled removes tools from the registered set.
+	Disabled []string `yaml:"disabled,omitempty"`
+}
+
+type TimeoutsConfig struct {
+	TerminalRun time.Duration `yaml:"terminal_run"`
+	MCP         time.Duration `yaml:"mcp"`
+	LSP         time.Duration `yaml:"lsp"`
+	WebFetch    time.Duration `yaml:"web_fetch"`
+}
+
+type RetryConfig struct {
+	MaxAttempts int           `yaml:"max_attempts"`
+	BaseDelay   time.Duration `yaml:"base_delay"`
+	MaxDelay    time.Duration `yaml:"max_delay"`
+}
+
+type LoopConfig struct {
+	MaxToolRounds    int `yaml:"max_tool_rounds"`
+	MaxRepeatedCalls int `yaml:"max_repeated_calls"`
+}
+
+type OutputConfig struct {
+	// Format selects the renderer: "text" for the colored transcript or
+	// "json" for one JSON event per line.
+	Format string `yaml:"format"`
+	// MaxToolResultChars truncates tool results sent back to the model.
+	// Zero disables truncation.
+	MaxToolResultChars int `yaml:"max_tool_result_chars"`
+	// SessionLogDir holds a JSON-lines log per session of the file changes
+	// and task lists. Empty disables the log.
+	SessionLogDir string `yaml:"session_log_dir"`
+}
+
+// providerPresets maps well-known provider names to their OpenAI-compatible
+// endpoint and the environment variable holding the API key.
+var providerPresets = map[string]ProviderConfig{
+	"groq":       {Name: "groq", BaseURL: "https://api.groq.com/openai/v1", APIKeyEnv: "GROQ_API_KEY"},
+	"openai":     {Name: "openai", BaseURL: "https://api.openai.com/v1", APIKeyEnv: "OPENAI_API_KEY"},
+	"openrouter": {Name: "openrouter", BaseURL: "https://openrouter.ai/api/v1", APIKeyEnv: "OPENROUTER_API_KEY"},
+	"ollama":     {Name: "ollama", BaseURL: "http://localhost:11434/v1", APIKeyEnv: "OLLAMA_API_KEY"},
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Provider:  ProviderConfig{Name: "groq"},
+		Model:     defaultModel,
+		MaxTokens: 4000,
+		Timeouts: TimeoutsConfig{
+			TerminalRun: 30 * time.Second,
+			MCP:         60 * time.Second,
+			LSP:         60 * time.Second,
+			WebFetch:    30 * time.Second,
+		},
+		Retry: RetryConfig{
+			MaxAttempts: DefaultRetryPolicy.MaxAttempts,
+			BaseDelay:   DefaultRetryPolicy.BaseDelay,
+			MaxDelay:    DefaultRetryPolicy.MaxDelay,
+		},
+		Loop: LoopConfig{
+			MaxToolRounds:    defaultMaxToolRounds,
+			MaxRepeatedCalls: defaultMaxRepeatedCalls,
+		},
+		Permissions: PermissionConfig{
+			Default: PermissionAllow,
+		},
+		Output: OutputConfig{
+			Format:             "text",
+			MaxToolResultChars: 50000,
+			SessionLogDir:      defaultSessionLogDir(),
+		},
+		Web: WebConfig{
+			CacheTTL: 15 * time.Minute,
+		},
+	}
+}
+
+var (
+	userConfigNames    = []string{"config.yaml", "config.yml", "config.json"}
+	projectConfigNames = []string{".agent.yaml", ".agent.yml", ".agent.json"}
+)
+
+// userConfigPath returns the first existing user-global config file, e.g.
+// ~/.config/agent/config.yaml, or "" if there is none.
+func userConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return firstExisting(filepath.Join(dir, "agent"), userConfigNames)
+}
+
+// projectConfigPath returns the first existing project-local config file in
+// the working directory, or "" if there is none.
+func projectConfigPath() string {
+	return firstExisting(".", projectConfigNames)
+}
+
+func firstExisting(dir string, names []string) string {
+	for _, name := range names {
+		p := filepath.Join(dir, name)
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	return ""
+}
+
+// mergeConfigFile decodes the file at path over cfg. YAML is a superset of
+// JSON, so both formats go through the same decoder.
+func mergeConfigFile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	cfg.Sources = append(cfg.Sources, path)
+	return nil
+}
+
+// mergeEnv applies AGENT_* environment variables over cfg.
+func mergeEnv(cfg *Config) error {
+	if name := os.Getenv("AGENT_PROVIDER"); name != "" {
+		cfg.setProvider(name)
+	}
+	if v := os.Getenv("AGENT_BASE_URL"); v != "" {
+		cfg.Provider.BaseURL = v
+	}
+	if v := os.Getenv("AGENT_API_KEY_ENV"); v != "" {
+		cfg.Provider.APIKeyEnv = v
+	}
+	if v := os.Getenv("AGENT_MODEL"); v != "" {
+		cfg.Model = v
+	}
+	// GROQ_FALLBACK_MODEL predates the config file and is still honored
+	if v := os.Getenv("GROQ_FALLBACK_MODEL"); v != "" {
+		cfg.FallbackModel = v
+	}
+	if v := os.Getenv("AGENT_FALLBACK_MODEL"); v != "" {
+		cfg.FallbackModel = v
+	}
+	if v := os.Getenv("AGENT_VISION"); v != "" {
+		vision, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid AGENT_VISION: %w", err)
+		}
+		cfg.Vision = &vision
+	}
+	if v := os.Getenv("AGENT_TEMPERATURE"); v != "" {
+		t, err := strconv.ParseFloat(v, 32)
+		if err != nil {
+			return fmt.Errorf("invalid AGENT_TEMPERATURE: %w", err)
+		}
+		temperature := float32(t)
+		cfg.Temperature = &temperature
+	}
+	if v := os.Getenv("AGENT_MAX_TOKENS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid AGENT_MAX_TOKENS: %w", err)
+		}
+		cfg.MaxTokens = n
+	}
+	if v := os.Getenv("AGENT_TERMINAL_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid AGENT_TERMINAL_TIMEOUT: %w", err)
+		}
+		cfg.Timeouts.TerminalRun = d
+	}
+	if v := os.Getenv("AGENT_PERMISSION_MODE"); v != "" {
+		cfg.Permissions.Default = PermissionMode(v)
+	}
+	if v := os.Getenv("AGENT_REVIEW_EDITS"); v != "" {
+		review, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid AGENT_REVIEW_EDITS: %w", err)
+		}
+		cfg.Permissions.ReviewEdits = review
+	}
+	if v := os.Getenv("AGENT_PLAN_MODE"); v != "" {
+		plan, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid AGENT_PLAN_MODE: %w", err)
+		}
+		cfg.PlanMode = plan
+	}
+	if v := os.Getenv("AGENT_MAX_TOOL_OUTPUT"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid AGENT_MAX_TOOL_OUTPUT: %w", err)
+		}
+		cfg.Output.MaxToolResultChars = n
+	}
+	return nil
+}
+
+// setProvider switches to a named provider. Its endpoint and key variable
+// are cleared so that resolveProvider fills them from the preset.
+func (c *Config) setProvider(name string) {
+	c.Provider = ProviderConfig{Name: name}
+}
+
+// resolveProvider fills an unset base URL or key variable from the preset
+// matching the provider name.
+func (c *Config) resolveProvider() {
+	preset, ok := providerPresets[c.Provider.Name]
+	if !ok {
+		return
+	}
+	if c.Provider.BaseURL == "" {
+		c.Provider.BaseURL = preset.BaseURL
+	}
+	if c.Provider.APIKeyEnv == "" {
+		c.Provider.APIKeyEnv = preset.APIKeyEnv
+	}
+}
+
+// configFlags holds the command-line overrides. Only flags that were set
+// explicitly are applied, so an unset flag never masks a config file value.
+type configFlags struct {
+	set *flag.FlagSet
+
+	configPath  string
+	provider    string
+	baseURL     string
+	model       string
+	temperature float64
+	maxTokens   int
+	permission  string
+	review      bool
+	plan        bool
+	output      string
+}
+
+func newConfigFlags(name string) *configFlags {
+	f := &configFlags{set: flag.NewFlagSet(name, flag.ContinueOnError)}
+	f.set.StringVar(&f.configPath, "config", "", "additional config file applied after the user and project files")
+	f.set.StringVar(&f.provider, "provider", "", "provider name (groq, openai, openrouter, ollama)")
+	f.set.StringVar(&f.baseURL, "base-url", "", "OpenAI-compatible API base URL")
+	f.set.StringVar(&f.model, "model", "", "model name")
+	f.set.Float64Var(&f.temperature, "temperature", 0, "sampling temperature")
+	f.set.IntVar(&f.maxTokens, "max-tokens", 0, "maximum tokens per completion")
+	f.set.StringVar(&f.permission, "permission", "", "default tool permission (allow, ask, deny)")
+	f.set.BoolVar(&f.review, "review", false, "show each file change as a diff and ask before applying it")
+	f.set.BoolVar(&f.plan, "plan", false, "start in plan mode: read-only tools until a plan is approved")
+	f.set.StringVar(&f.output, "output", "", "output format (text, json)")
+	return f
+}
+
+func (f *configFlags) apply(cfg *Config) {
+	f.set.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "provider":
+			cfg.setProvider(f.provider)
+		case "base-url":
+			cfg.Provider.BaseURL = f.baseURL
+		case "model":
+			cfg.Model = f.model
+		case "temperature":
+			temperature := float32(f.temperature)
+			cfg.Temperature = &temperature
+		case "max-tokens":
+			cfg.MaxTokens = f.maxTokens
+		case "permission":
+			cfg.Permissions.Default = PermissionMode(f.permission)
+		case "review":
+			cfg.Permissions.ReviewEdits = f.review
+		case "plan":
+			cfg.PlanMode = f.plan
+		case "output":
+			cfg.Output.Format = f.output
+		}
+	})
+}
+
+// LoadConfig builds the effective configuration from every layer.
+func LoadConfig(flags *configFlags) (Config, error) {
+	cfg := DefaultConfig()
+
+	paths := []string{userConfigPath(), projectConfigPath()}
+	if flags != nil && flags.configPath != "" {
+		if _, err := os.Stat(flags.configPath); err != nil {
+			return cfg, fmt.Errorf("config file: %w", err)
+		}
+		paths = append(paths, flags.configPath)
+	}
+	for _, p := range paths {
+		if p == "" {
+			continue
+		}
+		if err := mergeConfigFile(&cfg, p); err != nil {
+			return cfg, err
+		}
+	}
+
+	if err := mergeEnv(&cfg); err != nil {
+		return cfg, err
+	}
+	if flags != nil {
+		flags.apply(&cfg)
+	}
+	cfg.resolveProvider()
+
+	return cfg, cfg.Validate()
+}
+
+func (c Config) Validate() error {
+	var errs []error
+	if c.Model == "" {
+		errs = append(errs, errors.New("model must be set"))
+	}
+	if c.Provider.BaseURL == "" {
+		errs = append(errs, fmt.Errorf("provider %q has no base_url", c.Provider.Name))
+	}
+	if c.MaxTokens < 0 {
+		errs = append(errs, errors.New("max_tokens cannot be negative"))
+	}
+	if c.Temperature != nil && (*c.Temperature < 0 || *c.Temperature > 2) {
+		errs = append(errs, errors.New("temperature must be between 0 and 2"))
+	}
+	if c.Timeouts.TerminalRun <= 0 {
+		errs = append(errs, errors.New("timeouts.terminal_run must be positive"))
+	}
+	if c.Timeouts.MCP <= 0 {
+		errs = append(errs, errors.New("timeouts.mcp must be positive"))
+	}
+	for name, server := range c.MCPServers {
+		if server.Command == "" && server.URL == "" {
+			errs = append(errs, fmt.Errorf("mcp server %s needs a command or a url", name))
+		}
+	}
+	if c.Timeouts.LSP <= 0 {
+		errs = append(errs, errors.New("timeouts.lsp must be positive"))
+	}
+	for name, server := range c.LSPServers {
+		if server.Command == "" {
+			errs = append(errs, fmt.Errorf("lsp server %s needs a command", name))
+		}
+		if len(server.Extensions) == 0 {
+			errs = append(errs, fmt.Errorf("lsp server %s needs a list of file extensions", name))
+		}
+	}
+	for i, hook := range c.PostWrite {
+		if _, err := filepath.Match(hook.Pattern, ""); hook.Pattern == "" || err != nil {
+			errs = append(errs, fmt.Errorf("post_write[%d] needs a valid file pattern", i))
+		}
+		if hook.Format == "" && hook.Lint == "" {
+			errs = append(errs, fmt.Errorf("post_write[%d] needs a format or lint command", i))
+		}
+	}
+	if c.Timeouts.WebFetch <= 0 {
+		errs = append(errs, errors.New("timeouts.web_fetch must be positive"))
+	}
+	if err := c.Web.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.Output.Format != "text" && c.Output.Format != "json" {
+		errs = append(errs, fmt.Errorf("invalid output.format %q (want text or json)", c.Output.Format))
+	}
+	if err := c.Permissions.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.checkToolNames(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Hooks.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// APIKey reads the provider's API key from its configured variable.
+func (c Config) APIKey() string {
+	return os.Getenv(c.Provider.APIKeyEnv)
+}
+
+func (c Config) RetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:   c.Retry.MaxAttempts,
+		BaseDelay:     c.Retry.BaseDelay,
+		MaxDelay:      c.Retry.MaxDelay,
+		FallbackModel: c.FallbackModel,
+	}
+}
+
+// FilterTools applies the enabled/disabled lists to the registered tools.
+func (c Config) FilterTools(tools []ToolDefinition) []ToolDefinition {
+	enabled := map[string]bool{}
+	for _, name := range c.Tools.Enabled {
+		enabled[name] = true
+	}
+	disabled := map[string]bool{}
+	for _, name := range c.Tools.Disabled {
+		disabled[name] = true
+	}
+
+	var filtered []ToolDefinition
+	for _, tool := range tools {
+		if len(enabled) > 0 && !enabled[tool.Name] {
+			continue
+		}
+		if disabled[tool.Name] {
+			continue
+		}
+		filtered = append(filtered, tool)
+	}
+	return filtered
+}
+
+// checkToolNames reports names in the tool lists that match no tool the
+// agent can offer, since a typo in enabled would otherwise remove every
+// tool. MCP server tools are only known once the server is connected, so
+// any name under a configured server's prefix is accepted.
+func (c Config) checkToolNames() error {
+	known := map[string]bool{}
+	optional := slices.Concat(
+		(&WebClient{search: &httpSearch{}}).toolDefinitions(),
+		(&LSPManager{}).toolDefinitions(),
+		(&MCPManager{}).resourceToolDefinitions(),
+	)
+	for _, tool := range slices.Concat(allTools, optional) {
+		known[tool.Name] = true
+	}
+	isKnown := func(name string) bool {
+		if known[name] {
+			return true
+		}
+		for server := range c.MCPServers {
+			if strings.HasPrefix(name, mcpToolName(server, "")) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var errs []error
+	for _, name := range c.Tools.Enabled {
+		if !isKnown(name) {
+			errs = append(errs, fmt.Errorf("unknown tool %q in tools.enabled", name))
+		}
+	}
+	for _, name := range c.Tools.Disabled {
+		if !isKnown(name) {
+			errs = append(errs, fmt.Errorf("unknown tool %q in tools.disabled", name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// WriteTo prints the effective configuration as YAML, preceded by the list
+// of files it was loaded from.
+func (c Config) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	b.WriteString("# Effective configuration\n")
+	if len(c.Sources) == 0 {
+		b.WriteString("# (no config files found; defaults, environment and flags only)\n")
+	}
+	for _, source := range c.Sources {
+		fmt.Fprintf(&b, "# loaded: %s\n", source)
+	}
+
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return 0, err
+	}
+	b.Write(data)
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	maxDelegatedTasks    = 8 // tasks accepted by one delegate_task call
+	maxParallelDelegates = 4 // sub-agents running at the same time
+)
+
+// delegateTaskName is kept apart from the definition, which would otherwise
+// refer to itself through newSubAgent.
+const delegateTaskName = "delegate_task"
+
+var DelegateTaskDefinition = ToolDefinition{
+	Name: delegateTaskName,
+	Description: `Delegate self-contained tasks to sub-agents. Each sub-agent starts with an empty conversation, works on its task with the tools you allow it, and returns only a short summary of its findings or changes, which keeps your own conversation small.
+
+Give each task a complete, standalone prompt: the sub-agent cannot see your conversation. Several tasks run in parallel, so they must not edit the same files.`,
+	InputSchema:   GenerateSchema[DelegateTaskInput](),
+	agentFunction: delegateTask,
+}
+
+type DelegateTaskInput struct {
+	Tasks []DelegatedTask `json:"tasks" jsonschema:"required" jsonschema_description:"The tasks to delegate, one sub-agent each"`
+}
+
+type DelegatedTask struct {
+	Prompt string   `json:"prompt" jsonschema:"required" jsonschema_description:"Complete instructions for the sub-agent, including all the context it needs"`
+	Tools  []string `json:"tools,omitempty" jsonschema_description:"Names of the tools the sub-agent may use. Defaults to all of your tools except delegate_task."`
+}
+
+// subAgentPrompt is the system message every sub-agent starts with.
+const subAgentPrompt = `You are a sub-agent working on one focused task for another agent, which cannot see your conversation.
+Complete the task with the tools available to you. When you are done, reply with a concise summary of what you found or changed, including file paths and any details the other agent needs; that summary is the only thing it will see.`
+
+func delegateTask(ctx context.Context, a *Agent, input json.RawMessage) (string, error) {
+	delegateInput := DelegateTaskInput{}
+	if err := json.Unmarshal(input, &delegateInput); err != nil {
+		return "", err
+	}
+	tasks := delegateInput.Tasks
+	if len(tasks) == 0 {
+		return "", fmt.Errorf("tasks cannot be empty")
+	}
+	if len(tasks) > maxDelegatedTasks {
+		return "", fmt.Errorf("at most %d tasks can be delegated at once, got %d", maxDelegatedTasks, len(tasks))
+	}
+
+	// Check every task before starting any of them
+	children := make([]*Agent, len(tasks))
+	usage := &subAgentUsage{}
+	approverMu := &sync.Mutex{}
+	for i, task := range tasks {
+		if strings.TrimSpace(task.Prompt) == "" {
+			return "", fmt.Errorf("task %d has an empty prompt", i+1)
+		}
+		child, err := a.newSubAgent(task.Tools, usage, approverMu)
+		if err != nil {
+			return "", fmt.Errorf("task %d: %w", i+1, err)
+		}
+		children[i] = child
+	}
+
+	summaries := make([]string, len(tasks))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxParallelDelegates)
+	for i, child := range children {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			summaries[i] = child.runSubAgent(ctx, tasks[i].Prompt)
+		}()
+	}
+	wg.Wait()
+
+	if len(summaries) > 1 {
+		for i, summary := range summaries {
+			summaries[i] = fmt.Sprintf("## Task %d\n%s", i+1, summary)
+		}
+	}
+	return fmt.Sprintf("%s\n\n[sub-agents used %d tokens: %d prompt, %d completion]",
+		strings.Join(summaries, "\n\n"), usage.total, usage.prompt, usage.completion), nil
+}
+
+// newSubAgent creates a child agent sharing a's client and settings, with
+// its own conversation and the named subset of a's tools. Sub-agents cannot
+// delegate further.
+func (a *Agent) newSubAgent(toolNames []string, usage *subAgentUsage, approverMu *sync.Mutex) (*Agent, error) {
+	var available []ToolDefinition
+	for _, tool := range a.availableTools() {
+		if tool.Name != delegateTaskName {
+			available = append(available, tool)
+		}
+	}
+
+	tools := available
+	if len(toolNames) > 0 {
+		tools = nil
+		for _, name := range toolNames {
+			i := slices.IndexFunc(available, func(tool ToolDefinition) bool { return tool.Name == name })
+			if i < 0 {
+				names := make([]string, len(available))
+				for j, tool := range available {
+					names[j] = tool.Name
+				}
+				return nil, fmt.Errorf("tool %q cannot be given to a sub-agent. Available tools: %s", name, strings.Join(names, ", "))
+			}
+			tools = append(tools, available[i])
+		}
+	}
+
+	child := &Agent{
+		client:             a.client,
+		tools:              tools,
+		model:              a.model,
+		vision:             a.vision,
+		temperature:        a.temperature,
+		maxTokens:          a.maxTokens,
+		retry:              a.retry,
+		maxToolRounds:      a.maxToolRounds,
+		maxRepeatedCalls:   a.maxRepeatedCalls,
+		permissions:        a.permissions,
+		reviewEdits:        a.reviewEdits,
+		maxToolResultChars: a.maxToolResultChars,
+		lsp:                a.lsp,
+		postWrite:          a.postWrite,
+		hooks:              a.hooks,
+		sessionID:          a.sessionID,
+		sessionLogDir:      a.sessionLogDir,
+	}
+	child.sink = &subAgentSink{parent: a.sink, usage: usage}
+	child.approver = &subAgentApprover{parent: a.approver, mu: approverMu}
+	return child, nil
+}
+
+// runSubAgent runs the child on prompt and returns its final reply.
+func (a *Agent) runSubAgent(ctx context.Context, prompt string) string {
+	a.appendMessage(
+		openai.ChatCompletionMessage{Role: openai.ChatMessageRoleSystem, Content: subAgentPrompt},
+		openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: prompt},
+	)
+	err := a.runTurn(ctx)
+
+	var summary string
+	history := a.History()
+	for i := len(history) - 1; i >= 0; i-- {
+		message := history[i]
+		if message.Role == openai.ChatMessageRoleAssistant && message.Content != "" {
+			summary = message.Content
+			break
+		}
+	}
+
+	stopped := a.approver.(*subAgentApprover).stopped
+	switch {
+	case err != nil:
+		summary = fmt.Sprintf("The sub-agent failed: %v\n%s", err, summary)
+	case stopped != "":
+		summary = fmt.Sprintf("The sub-agent was stopped because %s.\n%s", stopped, summary)
+	case summary == "":
+		summary = "The sub-agent finished without a summary."
+	}
+	return strings.TrimSpace(summary)
+}
+
+// subAgentUsage totals the tokens used by a group of sub-agents.
+type subAgentUsage struct {
+	mu                        sync.Mutex
+	prompt, completion, total int
+}
+
+// subAgentSink passes a sub-agent's tool activity, usage and retries on to
+// the parent's sink, so the user can follow the work and usage adds up.
+// Its messages stay private; only the final summary reaches the parent.
+// Events from parallel sub-agents are passed on one at a time.
+type subAgentSink struct {
+	parent EventSink
+	usage  *subAgentUsage
+}
+
+func (s *subAgentSink) Emit(e Event) {
+	s.usage.mu.Lock()
+	defer s.usage.mu.Unlock()
+	switch e := e.(type) {
+	case UsageEvent:
+		s.usage.prompt += e.PromptTokens
+		s.usage.completion += e.CompletionTokens
+		s.usage.total += e.TotalTokens
+		s.parent.Emit(e)
+	case ToolCallStartedEvent, ToolCallFinishedEvent, FileChangeEvent:
+		s.parent.Emit(e)
+	case ErrorEvent:
+		if e.Retrying {
+			s.parent.Emit(e)
+		}
+	}
+}
+
+// subAgentApprover asks the parent's approver one question at a time, since
+// parallel sub-agents share it. A sub-agent that hits a tool loop limit is
+// stopped rather than paused.
+type subAgentApprover struct {
+	parent  Approver
+	mu      *sync.Mutex
+	stopped string
+}
+
+func (s *subAgentApprover) ApproveToolCall(id, name string, input json.RawMessage) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.parent.ApproveToolCall(id, name, input)
+}
+
+func (s *subAgentApprover) ReviewFileChange(id string, change FileChange) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.parent.ReviewFileChange(id, change)
+}
+
+func (s *subAgentApprover) ApprovePlan(plan string) bool {
+	return false // sub-agents never run in plan mode
+}
+
+func (s *subAgentApprover) ContinueToolLoop(reason string) (loopDecision, string) {
+	s.stopped = reason
+	return loopAbort, ""
+}
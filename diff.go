@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// FileChange describes a change a tool is about to make to one file.
+// OldPath is set for renames; Diff is a unified diff of the contents.
+type FileChange struct {
+	Path    string `json:"path"`
+	OldPath string `json:"old_path,omitempty"`
+	Created bool   `json:"created,omitempty"`
+	Deleted bool   `json:"deleted,omitempty"`
+	Diff    string `json:"diff"`
+}
+
+// invalidInputError marks a preview that failed because of the tool input,
+// such as an old_str that doesn't match. The tool fails the same way without
+// changing anything, so the call goes ahead and reports the error itself.
+type invalidInputError struct{ err error }
+
+func (e invalidInputError) Error() string { return e.err.Error() }
+func (e invalidInputError) Unwrap() error { return e.err }
+
+func invalidInput(err error) error {
+	return invalidInputError{err}
+}
+
+// previewOne adapts the preview of a tool that changes a single file.
+func previewOne(preview func(json.RawMessage) (*FileChange, error)) func(json.RawMessage) ([]FileChange, error) {
+	return func(input json.RawMessage) ([]FileChange, error) {
+		change, err := preview(input)
+		if err != nil {
+			return nil, err
+		}
+		return []FileChange{*change}, nil
+	}
+}
+
+// deletedFileChange diffs the current contents of path against nothing.
+func deletedFileChange(path string) (*FileChange, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &FileChange{
+		Path:    path,
+		Deleted: true,
+		Diff:    unifiedDiff("a/"+path, "/dev/null", string(content), ""),
+	}, nil
+}
+
+// newFileChange diffs the current contents of path against newContent.
+func newFileChange(path, newContent string) (*FileChange, error) {
+	oldContent, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	created := os.IsNotExist(err)
+
+	oldName := "a/" + path
+	if created {
+		oldName = "/dev/null"
+	}
+	return &FileChange{
+		Path:    path,
+		Created: created,
+		Diff:    unifiedDiff(oldName, "b/"+path, string(oldContent), newContent),
+	}, nil
+}
+
+const diffContext = 3 // unchanged lines shown around each change
+
+// maxDiffCells bounds the size of the table used to diff the changed middle
+// of two files. Larger changes are shown as a full replacement.
+const maxDiffCells = 4_000_000
+
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	line string
+}
+
+// unifiedDiff returns the unified diff between two texts, or "" when they
+// are equal.
+func unifiedDiff(oldName, newName, oldText, newText string) string {
+	if oldText == newText {
+		return ""
+	}
+	ops := diffLines(splitLines(oldText), splitLines(newText))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", oldName, newName)
+
+	// Walk the ops, emitting a hunk for each run of changes together with
+	// its surrounding context.
+	oldLine, newLine := 1, 1
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			oldLine++
+			newLine++
+			i++
+			continue
+		}
+
+		start := max(i-diffContext, 0)
+		for j := start; j < i; j++ {
+			oldLine--
+			newLine--
+		}
+		end := i
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			run := end
+			for run < len(ops) && ops[run].kind == ' ' {
+				run++
+			}
+			if run == len(ops) || run-end > 2*diffContext {
+				end = min(end+diffContext, len(ops))
+				break
+			}
+			end = run
+		}
+
+		var oldCount, newCount int
+		for _, op := range ops[start:end] {
+			if op.kind != '+' {
+				oldCount++
+			}
+			if op.kind != '-' {
+				newCount++
+			}
+		}
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n", hunkRange(oldLine, oldCount), hunkRange(newLine, newCount))
+		for _, op := range ops[start:end] {
+			b.WriteByte(op.kind)
+			b.WriteString(op.line)
+			b.WriteByte('\n')
+		}
+		oldLine += oldCount
+		newLine += newCount
+		i = end
+	}
+	return b.String()
+}
+
+func hunkRange(start, count int) string {
+	if count == 0 {
+		start--
+	}
+	if count == 1 {
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	for i, l := range lines {
+		if strings.HasSuffix(l, "\n") {
+			lines[i] = l[:len(l)-1]
+		} else {
+			lines[i] = l + "\n\\ No newline at end of file"
+		}
+	}
+	return lines
+}
+
+// diffLines computes a line diff. Common leading and trailing lines are
+// matched first, which keeps the usual small edit to a large file cheap;
+// the rest is aligned with a longest-common-subsequence table.
+func diffLines(a, b []string) []diffOp {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var ops []diffOp
+	for _, l := range a[:prefix] {
+		ops = append(ops, diffOp{' ', l})
+	}
+	ops = append(ops, diffMiddle(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, l := range a[len(a)-suffix:] {
+		ops = append(ops, diffOp{' ', l})
+	}
+	return ops
+}
+
+func diffMiddle(a, b []string) []diffOp {
+	var ops []diffOp
+	if len(a)*len(b) > maxDiffCells {
+		for _, l := range a {
+			ops = append(ops, diffOp{'-', l})
+		}
+		for _, l := range b {
+			ops = append(ops, diffOp{'+', l})
+		}
+		return ops
+	}
+
+	// lcs[i][j] is the LCS length of a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	return ops
+}
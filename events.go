@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Event is something that happened while the agent worked. Front ends
+// receive events through an EventSink and decide how to present them.
+type Event interface {
+	EventType() string
+}
+
+// UserMessageEvent carries what the user typed. Attachments describes the
+// files, directories and images it mentioned that were added to it.
+type UserMessageEvent struct {
+	Content     string   `json:"content"`
+	Attachments []string `json:"attachments,omitempty"`
+}
+
+// AwaitingInputEvent is emitted when the REPL is ready for the next user
+// message.
+type AwaitingInputEvent struct{}
+
+// AssistantDeltaEvent carries a fragment of a reply as it streams in.
+type AssistantDeltaEvent struct {
+	Content string `json:"content"`
+}
+
+// AssistantMessageEvent carries a complete reply. In stream mode its content
+// has already been delivered as deltas.
+type AssistantMessageEvent struct {
+	Content  string `json:"content"`
+	Streamed bool   `json:"streamed,omitempty"`
+}
+
+type ToolCallStartedEvent struct {
+	ID    string          `json:"id"`
+	Name  string          `json:"name"`
+	Input json.RawMessage `json:"input"`
+}
+
+type ToolCallFinishedEvent struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Result  string `json:"result"`
+	IsError bool   `json:"is_error,omitempty"`
+}
+
+// FileChangeEvent shows a file change as a diff before the tool makes it.
+type FileChangeEvent struct {
+	ID   string `json:"id"`
+	Tool string `json:"tool"`
+	FileChange
+}
+
+// TodoListEvent carries the whole task list each time the model changes it.
+type TodoListEvent struct {
+	Todos []TodoItem `json:"todos"`
+}
+
+// ErrorEvent reports a failure. Retrying is set for transient errors the
+// agent is about to retry on its own; Resumable for failed turns that can
+// be resumed without new input.
+type ErrorEvent struct {
+	Message   string `json:"message"`
+	Retrying  bool   `json:"retrying,omitempty"`
+	Resumable bool   `json:"resumable,omitempty"`
+}
+
+// UsageEvent reports the tokens used by one inference call.
+type UsageEvent struct {
+	Model            string `json:"model"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+	TotalTokens      int    `json:"total_tokens"`
+}
+
+func (UserMessageEvent) EventType() string      { return "user_message" }
+func (AwaitingInputEvent) EventType() string    { return "awaiting_input" }
+func (AssistantDeltaEvent) EventType() string   { return "assistant_delta" }
+func (AssistantMessageEvent) EventType() string { return "assistant_message" }
+func (ToolCallStartedEvent) EventType() string  { return "tool_call_started" }
+func (ToolCallFinishedEvent) EventType() string { return "tool_call_finished" }
+func (FileChangeEvent) EventType() string       { return "file_change" }
+func (TodoListEvent) EventType() string         { return "todo_list" }
+func (ErrorEvent) EventType() string            { return "error" }
+func (UsageEvent) EventType() string            { return "usage" }
+
+// EventSink receives the agent's events. Emit is called synchronously from
+// the agent loop, so implementations should not block for long.
+type EventSink interface {
+	Emit(Event)
+}
+
+// EventSinkFunc adapts a function to an EventSink.
+type EventSinkFunc func(Event)
+
+func (f EventSinkFunc) Emit(e Event) { f(e) }
+
+// Approver makes the decisions the agent cannot make on its own.
+type Approver interface {
+	// ApproveToolCall decides tool calls whose permission mode is "ask".
+	ApproveToolCall(id, name string, input json.RawMessage) bool
+	// ReviewFileChange decides file changes when edits are reviewed.
+	ReviewFileChange(id string, change FileChange) bool
+	// ApprovePlan decides whether a plan written in plan mode is carried out.
+	ApprovePlan(plan string) bool
+	// ContinueToolLoop decides what to do when a tool loop limit is hit.
+	ContinueToolLoop(reason string) (loopDecision, string)
+}
+
+// JSONLinesRenderer writes every event as one JSON object per line, with
+// its type and a timestamp alongside the event's own fields.
+type JSONLinesRenderer struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func NewJSONLinesRenderer(w io.Writer) *JSONLinesRenderer {
+	return &JSONLinesRenderer{enc: json.NewEncoder(w)}
+}
+
+func (r *JSONLinesRenderer) Emit(e Event) {
+	fields := map[string]any{}
+	if data, err := json.Marshal(e); err == nil {
+		json.Unmarshal(data, &fields)
+	}
+	fields["type"] = e.EventType()
+	fields["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.enc.Encode(fields)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// writeFileAtomic replaces path with data so that readers, and the file
+// after a crash, see either the old contents or the new ones, never a mix.
+// The data goes to a temporary file in the same directory, which is synced
+// and renamed over path. An existing file keeps its mode, including the
+// setuid, setgid and sticky bits, and, where the platform allows, its
+// owner; a new file gets perm less the umask, as with os.WriteFile.
+// Symlinks are followed, so the link stays in place and its target is
+// replaced.
+func writeFileAtomic(path string, data []byte, perm fs.FileMode) error {
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		path = target
+	}
+
+	info, err := os.Stat(path)
+	switch {
+	case err == nil:
+		if info.IsDir() {
+			return fmt.Errorf("%s is a directory", path)
+		}
+		perm = info.Mode() & (fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky)
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	// Only an existing file's mode is set explicitly; a new one is created
+	// with perm so that the umask applies
+	tmpPerm := perm
+	if info != nil {
+		tmpPerm = 0o600
+	}
+	tmp, err := createTemp(dir, "."+name+".tmp-", tmpPerm)
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer func() {
+		if tmp != nil {
+			tmp.Close()
+			os.Remove(tmpName)
+		}
+	}()
+
+	if _, err := tmp.Write(data); err != nil {
+		return err
+	}
+	if info != nil {
+		// Changing the owner clears the setuid and setgid bits, so the mode
+		// is set after it
+		preserveOwner(tmp, info)
+		if err := tmp.Chmod(perm); err != nil {
+			return err
+		}
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		return err
+	}
+	tmp = nil
+
+	syncDir(dir)
+	return nil
+}
+
+// createTemp is os.CreateTemp with a mode for the new file, to which the
+// umask applies.
+func createTemp(dir, prefix string, perm fs.FileMode) (*os.File, error) {
+	for range 10000 {
+		f, err := os.OpenFile(filepath.Join(dir, prefix+strconv.FormatUint(uint64(rand.Uint32()), 10)), os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+		if !os.IsExist(err) {
+			return f, err
+		}
+	}
+	return nil, &fs.PathError{Op: "createtemp", Path: filepath.Join(dir, prefix+"*"), Err: fs.ErrExist}
+}
+
+// syncDir makes a rename in dir durable. Not every platform can sync a
+// directory, so failures are ignored.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// applyEdit replaces oldStr with newStr in content. A file whose lines all
+// end in CRLF is edited as if it used LF and converted back, so that
+// replacement text written with LF matches and keeps the file's style. A
+// trailing newline is kept if the file had one.
+func applyEdit(content, oldStr, newStr string) (string, error) {
+	if oldStr == "" {
+		if content != "" {
+			return "", fmt.Errorf("old_str is empty but the file already has content; give the text to replace")
+		}
+		return newStr, nil
+	}
+
+	crlf := strings.Contains(content, "\r\n") && strings.Count(content, "\r\n") == strings.Count(content, "\n")
+	if crlf {
+		content = strings.ReplaceAll(content, "\r\n", "\n")
+		oldStr = strings.ReplaceAll(oldStr, "\r\n", "\n")
+		newStr = strings.ReplaceAll(newStr, "\r\n", "\n")
+	}
+
+	updated := strings.Replace(content, oldStr, newStr, -1)
+	if updated == content {
+		return "", fmt.Errorf("old_str not found in file")
+	}
+	if strings.HasSuffix(content, "\n") && updated != "" && !strings.HasSuffix(updated, "\n") {
+		updated += "\n"
+	}
+
+	if crlf {
+		updated = strings.ReplaceAll(updated, "\n", "\r\n")
+	}
+	return updated, nil
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import (
+	"io/fs"
+	"os"
+)
+
+// preserveOwner is a no-op where files have no Unix owner.
+func preserveOwner(f *os.File, info fs.FileInfo) {}
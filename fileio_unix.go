@@ -0,0 +1,17 @@
+//go:build unix
+
+package main
+
+import (
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// preserveOwner gives f the owner and group of the file described by info.
+// Only privileged processes can give files away, so failures are ignored.
+func preserveOwner(f *os.File, info fs.FileInfo) {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		f.Chown(int(stat.Uid), int(stat.Gid))
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// fileTracker remembers the version of each file the model has seen, so
+// that changes based on an outdated read are refused. The zero value is
+// ready to use.
+type fileTracker struct {
+	mu   sync.Mutex
+	seen map[string]fileState // keyed by absolute path
+}
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+	hash    [sha256.Size]byte
+}
+
+func trackerKey(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
+
+func statFile(path string) (fileState, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileState{}, err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fileState{}, err
+	}
+	return fileState{modTime: info.ModTime(), size: info.Size(), hash: sha256.Sum256(content)}, nil
+}
+
+// record notes the current version of path as seen by the model, after it
+// read or wrote the file. A file that no longer exists is forgotten.
+func (t *fileTracker) record(path string) {
+	state, err := statFile(path)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.seen == nil {
+		t.seen = map[string]fileState{}
+	}
+	if err != nil {
+		delete(t.seen, trackerKey(path))
+		return
+	}
+	t.seen[trackerKey(path)] = state
+}
+
+// check returns an error for the model if path exists but was never read,
+// or has changed on disk since the model last saw it.
+func (t *fileTracker) check(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil // new files need no read first
+	}
+
+	t.mu.Lock()
+	state, ok := t.seen[trackerKey(path)]
+	t.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%s already exists and you have not read it; read it with read_file before changing it", path)
+	}
+
+	// Unchanged size and mtime are taken at their word; otherwise compare
+	// contents, since touching a file doesn't make it stale
+	if info.ModTime().Equal(state.modTime) && info.Size() == state.size {
+		return nil
+	}
+	current, err := statFile(path)
+	if err != nil || current.hash != state.hash {
+		return fmt.Errorf("%s has changed on disk since you last read it; read it again with read_file before changing it", path)
+	}
+	return nil
+}
@@ -5,6 +5,9 @@ go 1.25.0
 require (
 	github.com/invopop/jsonschema v0.13.0
 	github.com/sashabaranov/go-openai v1.41.1
+	golang.org/x/net v0.58.0
+	golang.org/x/term v0.45.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -13,5 +16,5 @@ require (
 	github.com/mailru/easyjson v0.7.7 // indirect
 	github.com/stretchr/testify v1.8.4 // indirect
 	github.com/wk8/go-ordered-map/v2 v2.1.8 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
+	golang.org/x/sys v0.47.0 // indirect
 )
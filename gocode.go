@@ -0,0 +1,834 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"go/types"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The Go tools read source with go/parser and go/types, so they work
+// offline and need nothing but the files themselves. Type information is
+// best effort: packages that fail to type-check still report what could be
+// resolved.
+
+const (
+	maxDefinitionLines = 150 // source lines shown per definition
+	maxReferences      = 300
+	goListTimeout      = 30 * time.Second // for listing export data, which builds dependencies
+)
+
+var GoOutlineDefinition = ToolDefinition{
+	Name:        "go_outline",
+	Description: "Outline a Go source file: its package, imports, and every declaration with its line range, struct fields and interface methods. Cheaper than reading the whole file.",
+	InputSchema: GenerateSchema[GoOutlineInput](),
+	Function:    GoOutline,
+	ReadOnly:    true,
+}
+
+type GoOutlineInput struct {
+	Path string `json:"path" jsonschema:"required" jsonschema_description:"Relative path of a .go file"`
+}
+
+var GoSymbolsDefinition = ToolDefinition{
+	Name:        "go_symbols",
+	Description: "List the top-level symbols of the Go package in a directory: types, functions, methods, constants and variables, with signatures and positions.",
+	InputSchema: GenerateSchema[GoSymbolsInput](),
+	Function:    GoSymbols,
+	ReadOnly:    true,
+}
+
+type GoSymbolsInput struct {
+	Path         string `json:"path,omitempty" jsonschema_description:"Relative path of the package directory. Defaults to the current directory."`
+	ExportedOnly bool   `json:"exported_only,omitempty" jsonschema_description:"Only list exported symbols"`
+}
+
+var GoDefinitionDefinition = ToolDefinition{
+	Name:        "go_definition",
+	Description: "Find where a Go symbol is defined and show its source with its doc comment. Names can be Name, Type.Method, Type.Field, pkg.Name or pkg.Type.Method.",
+	InputSchema: GenerateSchema[GoSymbolInput](),
+	Function:    GoDefinition,
+	ReadOnly:    true,
+}
+
+var GoReferencesDefinition = ToolDefinition{
+	Name:        "go_references",
+	Description: "Find every reference to a Go symbol across the packages under a directory, using type information so that unrelated identifiers with the same name are not reported. Names can be Name, Type.Method, Type.Field, pkg.Name or pkg.Type.Method.",
+	InputSchema: GenerateSchema[GoSymbolInput](),
+	Function:    GoReferences,
+	ReadOnly:    true,
+}
+
+var GoMethodsDefinition = ToolDefinition{
+	Name:        "go_methods",
+	Description: "Show the method set of a Go type, for both the value and pointer receiver, including methods promoted from embedded fields.",
+	InputSchema: GenerateSchema[GoSymbolInput](),
+	Function:    GoMethods,
+	ReadOnly:    true,
+}
+
+type GoSymbolInput struct {
+	Name string `json:"name" jsonschema:"required" jsonschema_description:"Symbol name, e.g. Agent, Agent.Run or pkg.Name"`
+	Path string `json:"path,omitempty" jsonschema_description:"Relative directory to search, including subdirectories. Defaults to the current directory."`
+}
+
+// goPackage is the files of one package in one directory, with their type
+// information once typeCheck has run.
+type goPackage struct {
+	dir   string
+	name  string
+	files []*ast.File
+	types *types.Package
+	info  *types.Info
+}
+
+type goWorkspace struct {
+	fset     *token.FileSet
+	packages []*goPackage
+}
+
+// loadGoPackages parses the packages in dir, and in its subdirectories when
+// recursive is set. Files excluded by build constraints for the current
+// platform are skipped, as are vendor, testdata and hidden directories.
+func loadGoPackages(dir string, recursive bool) (*goWorkspace, error) {
+	ws := &goWorkspace{fset: token.NewFileSet()}
+	byKey := map[string]*goPackage{}
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			name := d.Name()
+			if path != dir && (!recursive || name == "vendor" || name == "testdata" ||
+				strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(path, ".go") {
+			return nil
+		}
+		if ok, err := build.Default.MatchFile(filepath.Dir(path), d.Name()); err != nil || !ok {
+			return nil
+		}
+
+		file, err := parser.ParseFile(ws.fset, path, nil, parser.ParseComments)
+		if file == nil {
+			return err
+		}
+		key := filepath.Dir(path) + "\x00" + file.Name.Name
+		pkg, ok := byKey[key]
+		if !ok {
+			pkg = &goPackage{dir: filepath.Dir(path), name: file.Name.Name}
+			byKey[key] = pkg
+			ws.packages = append(ws.packages, pkg)
+		}
+		pkg.files = append(pkg.files, file)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(ws.packages) == 0 {
+		return nil, fmt.Errorf("no Go files found in %s", dir)
+	}
+	return ws, nil
+}
+
+// typeCheck fills in type information for every package under dir. Errors
+// are ignored so that one broken file or missing dependency doesn't hide
+// everything else.
+func (ws *goWorkspace) typeCheck(dir string) {
+	conf := types.Config{
+		Importer:    newGoImporter(ws.fset, dir),
+		Error:       func(error) {},
+		FakeImportC: true,
+	}
+	for _, pkg := range ws.packages {
+		pkg.info = &types.Info{
+			Defs:  map[*ast.Ident]types.Object{},
+			Uses:  map[*ast.Ident]types.Object{},
+			Types: map[ast.Expr]types.TypeAndValue{},
+		}
+		pkg.types, _ = conf.Check(pkg.dir, ws.fset, pkg.files, pkg.info)
+	}
+}
+
+// newGoImporter reads imported packages from the compiler's export data,
+// which the go command produces (or finds in its build cache) for all the
+// dependencies of the packages under dir in one go. Without a working go
+// command, or when it takes too long, imports are type-checked from source
+// instead, which is slower.
+func newGoImporter(fset *token.FileSet, dir string) types.Importer {
+	exports, err := goExportFiles(dir)
+	if err != nil {
+		return importer.ForCompiler(fset, "source", nil)
+	}
+	return importer.ForCompiler(fset, "gc", func(path string) (io.ReadCloser, error) {
+		file, ok := exports[path]
+		if !ok {
+			return nil, fmt.Errorf("no export data for %s", path)
+		}
+		return os.Open(file)
+	})
+}
+
+// goExportCache keeps the export data files listed for each directory,
+// since listing them builds every dependency, and failures to list them so
+// as not to wait on the go command again. An entry is reused until a tool
+// writes a Go file or the module's go.mod or go.sum changes.
+var goExportCache = struct {
+	sync.Mutex
+	entries map[string]goExports
+}{entries: map[string]goExports{}}
+
+type goExports struct {
+	files map[string]string // export data file by import path
+	err   error
+	stamp string // goModStamp when the files were listed
+}
+
+// goExportFiles lists the export data files of the dependencies of the
+// packages under dir. Modules are not downloaded: dependencies missing from
+// the module cache have no export data.
+func goExportFiles(dir string) (map[string]string, error) {
+	key, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	stamp := goModStamp(key)
+	goExportCache.Lock()
+	cached, ok := goExportCache.entries[key]
+	goExportCache.Unlock()
+	if ok && cached.stamp == stamp {
+		return cached.files, cached.err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), goListTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "go", "list", "-e", "-export", "-deps", "-f", "{{if .Export}}{{.ImportPath}}\t{{.Export}}{{end}}", "./...")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOPROXY=off")
+	cmd.WaitDelay = time.Second
+	out, err := cmd.Output()
+	var files map[string]string
+	if ctx.Err() != nil {
+		err = fmt.Errorf("go list did not finish within %s", goListTimeout)
+	}
+	if err == nil {
+		files = map[string]string{}
+		for _, line := range strings.Split(string(out), "\n") {
+			if path, file, ok := strings.Cut(line, "\t"); ok {
+				files[path] = file
+			}
+		}
+	}
+
+	goExportCache.Lock()
+	goExportCache.entries[key] = goExports{files: files, err: err, stamp: stamp}
+	goExportCache.Unlock()
+	return files, err
+}
+
+// goModStamp identifies the version of the go.mod and go.sum of the module
+// containing dir by their sizes and modification times.
+func goModStamp(dir string) string {
+	for ; ; dir = filepath.Dir(dir) {
+		if mod, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			stamp := fmt.Sprintf("%s %d %d", dir, mod.Size(), mod.ModTime().UnixNano())
+			if sum, err := os.Stat(filepath.Join(dir, "go.sum")); err == nil {
+				stamp += fmt.Sprintf(" %d %d", sum.Size(), sum.ModTime().UnixNano())
+			}
+			return stamp
+		}
+		if dir == filepath.Dir(dir) {
+			return ""
+		}
+	}
+}
+
+// invalidateGoExports drops the cached export data lists after Go source
+// changed, since the packages of the module itself are among them.
+func invalidateGoExports() {
+	goExportCache.Lock()
+	clear(goExportCache.entries)
+	goExportCache.Unlock()
+}
+
+func (ws *goWorkspace) position(pos token.Pos) string {
+	p := ws.fset.Position(pos)
+	return fmt.Sprintf("%s:%d", p.Filename, p.Line)
+}
+
+// sourceOf returns the source text between two positions.
+func (ws *goWorkspace) sourceOf(from, to token.Pos) string {
+	start, end := ws.fset.Position(from), ws.fset.Position(to)
+	content, err := os.ReadFile(start.Filename)
+	if err != nil || start.Offset > end.Offset || end.Offset > len(content) {
+		return ""
+	}
+	return string(content[start.Offset:end.Offset])
+}
+
+// nodeString prints an AST node on one line, the way gofmt would.
+func (ws *goWorkspace) nodeString(node any) string {
+	var b bytes.Buffer
+	printer.Fprint(&b, ws.fset, node)
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// funcSignature prints a function declaration without its body or doc.
+func (ws *goWorkspace) funcSignature(decl *ast.FuncDecl) string {
+	return ws.nodeString(&ast.FuncDecl{Recv: decl.Recv, Name: decl.Name, Type: decl.Type})
+}
+
+// receiverName returns the name of the type a method is declared on.
+func receiverName(decl *ast.FuncDecl) string {
+	if decl.Recv == nil || len(decl.Recv.List) == 0 {
+		return ""
+	}
+	expr := decl.Recv.List[0].Type
+	for {
+		switch e := expr.(type) {
+		case *ast.StarExpr:
+			expr = e.X
+		case *ast.IndexExpr:
+			expr = e.X
+		case *ast.IndexListExpr:
+			expr = e.X
+		case *ast.Ident:
+			return e.Name
+		default:
+			return ""
+		}
+	}
+}
+
+func typeKind(spec *ast.TypeSpec) string {
+	if spec.Assign.IsValid() {
+		return "alias"
+	}
+	switch spec.Type.(type) {
+	case *ast.StructType:
+		return "struct"
+	case *ast.InterfaceType:
+		return "interface"
+	case *ast.FuncType:
+		return "func type"
+	}
+	return "type"
+}
+
+func GoOutline(input json.RawMessage) (string, error) {
+	outlineInput := GoOutlineInput{}
+	if err := json.Unmarshal(input, &outlineInput); err != nil {
+		return "", err
+	}
+
+	ws := &goWorkspace{fset: token.NewFileSet()}
+	file, err := parser.ParseFile(ws.fset, outlineInput.Path, nil, parser.ParseComments)
+	if file == nil {
+		return "", err
+	}
+
+	lines := func(node ast.Node) string {
+		return fmt.Sprintf("L%d-%d", ws.fset.Position(node.Pos()).Line, ws.fset.Position(node.End()).Line)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "package %s\n", file.Name.Name)
+	if err != nil {
+		fmt.Fprintf(&b, "(parse errors: %v)\n", err)
+	}
+	if len(file.Imports) > 0 {
+		b.WriteString("imports:\n")
+		for _, imp := range file.Imports {
+			fmt.Fprintf(&b, "  %s\n", ws.nodeString(imp))
+		}
+	}
+
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			fmt.Fprintf(&b, "%s %s\n", lines(decl), ws.funcSignature(decl))
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					fmt.Fprintf(&b, "%s type %s %s\n", lines(spec), spec.Name.Name, typeKind(spec))
+					writeMembers(&b, ws, spec)
+				case *ast.ValueSpec:
+					names := make([]string, len(spec.Names))
+					for i, name := range spec.Names {
+						names[i] = name.Name
+					}
+					kind := "var"
+					if decl.Tok == token.CONST {
+						kind = "const"
+					}
+					typ := ""
+					if spec.Type != nil {
+						typ = " " + ws.nodeString(spec.Type)
+					}
+					fmt.Fprintf(&b, "%s %s %s%s\n", lines(spec), kind, strings.Join(names, ", "), typ)
+				}
+			}
+		}
+	}
+	return b.String(), nil
+}
+
+// writeMembers lists the fields of a struct or the methods of an interface.
+func writeMembers(b *strings.Builder, ws *goWorkspace, spec *ast.TypeSpec) {
+	var fields *ast.FieldList
+	switch t := spec.Type.(type) {
+	case *ast.StructType:
+		fields = t.Fields
+	case *ast.InterfaceType:
+		fields = t.Methods
+	}
+	if fields == nil {
+		return
+	}
+	for _, field := range fields.List {
+		names := make([]string, len(field.Names))
+		for i, name := range field.Names {
+			names[i] = name.Name
+		}
+		typ := ws.nodeString(field.Type)
+		if fn, ok := field.Type.(*ast.FuncType); ok {
+			typ = strings.TrimPrefix(ws.nodeString(fn), "func")
+			fmt.Fprintf(b, "    %s%s\n", strings.Join(names, ", "), typ)
+			continue
+		}
+		if len(names) == 0 {
+			fmt.Fprintf(b, "    %s (embedded)\n", typ)
+			continue
+		}
+		fmt.Fprintf(b, "    %s %s\n", strings.Join(names, ", "), typ)
+	}
+}
+
+func GoSymbols(input json.RawMessage) (string, error) {
+	symbolsInput := GoSymbolsInput{}
+	if err := json.Unmarshal(input, &symbolsInput); err != nil {
+		return "", err
+	}
+	dir := symbolsInput.Path
+	if dir == "" {
+		dir = "."
+	}
+
+	ws, err := loadGoPackages(dir, false)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for _, pkg := range ws.packages {
+		var typeLines, funcLines, methodLines, valueLines []string
+		include := func(name string) bool {
+			return !symbolsInput.ExportedOnly || ast.IsExported(name)
+		}
+		for _, file := range pkg.files {
+			for _, decl := range file.Decls {
+				switch decl := decl.(type) {
+				case *ast.FuncDecl:
+					if !include(decl.Name.Name) || (decl.Recv != nil && !include(receiverName(decl))) {
+						continue
+					}
+					line := fmt.Sprintf("  %s  %s", ws.funcSignature(decl), ws.position(decl.Pos()))
+					if decl.Recv != nil {
+						methodLines = append(methodLines, line)
+					} else {
+						funcLines = append(funcLines, line)
+					}
+				case *ast.GenDecl:
+					for _, spec := range decl.Specs {
+						switch spec := spec.(type) {
+						case *ast.TypeSpec:
+							if include(spec.Name.Name) {
+								typeLines = append(typeLines, fmt.Sprintf("  %s %s  %s", spec.Name.Name, typeKind(spec), ws.position(spec.Pos())))
+							}
+						case *ast.ValueSpec:
+							for _, name := range spec.Names {
+								if include(name.Name) && name.Name != "_" {
+									valueLines = append(valueLines, fmt.Sprintf("  %s %s  %s", decl.Tok, name.Name, ws.position(name.Pos())))
+								}
+							}
+						}
+					}
+				}
+			}
+		}
+
+		fmt.Fprintf(&b, "package %s (%s, %d files)\n", pkg.name, pkg.dir, len(pkg.files))
+		for _, section := range []struct {
+			title string
+			lines []string
+		}{{"types", typeLines}, {"functions", funcLines}, {"methods", methodLines}, {"constants and variables", valueLines}} {
+			if len(section.lines) > 0 {
+				fmt.Fprintf(&b, "%s:\n%s\n", section.title, strings.Join(section.lines, "\n"))
+			}
+		}
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+func GoDefinition(input json.RawMessage) (string, error) {
+	symbolInput, ws, err := loadForSymbol(input)
+	if err != nil {
+		return "", err
+	}
+	parts := strings.Split(symbolInput.Name, ".")
+
+	var results []string
+	for _, pkg := range ws.packages {
+		for _, query := range symbolQueries(pkg, parts) {
+			for _, file := range pkg.files {
+				for _, node := range findDeclarations(file, query) {
+					from := node.Pos()
+					if doc := docOf(node); doc != nil {
+						from = doc.Pos()
+					}
+					source := ws.sourceOf(from, node.End())
+					if lines := strings.Split(source, "\n"); len(lines) > maxDefinitionLines {
+						source = strings.Join(lines[:maxDefinitionLines], "\n") + "\n// ... truncated"
+					}
+					results = append(results, fmt.Sprintf("%s (package %s)\n```go\n%s\n```", ws.position(node.Pos()), pkg.name, source))
+				}
+			}
+		}
+	}
+	if len(results) == 0 {
+		return "", fmt.Errorf("no definition of %s found under %s", symbolInput.Name, symbolInput.Path)
+	}
+	return strings.Join(results, "\n\n"), nil
+}
+
+func loadForSymbol(input json.RawMessage) (GoSymbolInput, *goWorkspace, error) {
+	symbolInput := GoSymbolInput{}
+	if err := json.Unmarshal(input, &symbolInput); err != nil {
+		return symbolInput, nil, err
+	}
+	if symbolInput.Name == "" {
+		return symbolInput, nil, fmt.Errorf("name cannot be empty")
+	}
+	if symbolInput.Path == "" {
+		symbolInput.Path = "."
+	}
+	ws, err := loadGoPackages(symbolInput.Path, true)
+	return symbolInput, ws, err
+}
+
+// symbolQueries interprets a dotted name within pkg. The result holds the
+// top-level name and, for members, the member name; a leading package name
+// is dropped when it matches pkg and rules pkg out otherwise.
+func symbolQueries(pkg *goPackage, parts []string) [][]string {
+	switch len(parts) {
+	case 1:
+		return [][]string{parts}
+	case 2:
+		queries := [][]string{parts}
+		if parts[0] == pkg.name {
+			queries = append(queries, parts[1:])
+		}
+		return queries
+	case 3:
+		if parts[0] == pkg.name {
+			return [][]string{parts[1:]}
+		}
+	}
+	return nil
+}
+
+// findDeclarations returns the declarations in file matching query: a
+// top-level name, or a type name and the name of one of its methods,
+// fields or interface methods.
+func findDeclarations(file *ast.File, query []string) []ast.Node {
+	var nodes []ast.Node
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			switch {
+			case len(query) == 1 && decl.Recv == nil && decl.Name.Name == query[0]:
+				nodes = append(nodes, decl)
+			case len(query) == 2 && decl.Recv != nil && receiverName(decl) == query[0] && decl.Name.Name == query[1]:
+				nodes = append(nodes, decl)
+			}
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					if spec.Name.Name != query[0] {
+						continue
+					}
+					if len(query) == 1 {
+						if len(decl.Specs) == 1 {
+							nodes = append(nodes, decl)
+						} else {
+							nodes = append(nodes, spec)
+						}
+						continue
+					}
+					if field := findMember(spec, query[1]); field != nil {
+						nodes = append(nodes, field)
+					}
+				case *ast.ValueSpec:
+					if len(query) != 1 {
+						continue
+					}
+					for _, name := range spec.Names {
+						if name.Name == query[0] {
+							if len(decl.Specs) == 1 {
+								nodes = append(nodes, decl)
+							} else {
+								nodes = append(nodes, spec)
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	return nodes
+}
+
+func findMember(spec *ast.TypeSpec, name string) *ast.Field {
+	var fields *ast.FieldList
+	switch t := spec.Type.(type) {
+	case *ast.StructType:
+		fields = t.Fields
+	case *ast.InterfaceType:
+		fields = t.Methods
+	}
+	if fields == nil {
+		return nil
+	}
+	for _, field := range fields.List {
+		for _, n := range field.Names {
+			if n.Name == name {
+				return field
+			}
+		}
+	}
+	return nil
+}
+
+func docOf(node ast.Node) *ast.CommentGroup {
+	switch n := node.(type) {
+	case *ast.FuncDecl:
+		return n.Doc
+	case *ast.GenDecl:
+		return n.Doc
+	case *ast.TypeSpec:
+		return n.Doc
+	case *ast.ValueSpec:
+		return n.Doc
+	case *ast.Field:
+		return n.Doc
+	}
+	return nil
+}
+
+// lookupObjects resolves a dotted name to type-checked objects: package
+// level objects, or fields and methods of a named type.
+func lookupObjects(ws *goWorkspace, name string) []types.Object {
+	parts := strings.Split(name, ".")
+	var objects []types.Object
+	for _, pkg := range ws.packages {
+		if pkg.types == nil {
+			continue
+		}
+		for _, query := range symbolQueries(pkg, parts) {
+			obj := pkg.types.Scope().Lookup(query[0])
+			if obj == nil {
+				continue
+			}
+			if len(query) == 1 {
+				objects = append(objects, obj)
+				continue
+			}
+			if _, ok := obj.(*types.TypeName); !ok {
+				continue
+			}
+			member, _, _ := types.LookupFieldOrMethod(obj.Type(), true, pkg.types, query[1])
+			if member != nil {
+				objects = append(objects, member)
+			}
+		}
+	}
+	return objects
+}
+
+// objectKey identifies an object by where it is declared, since the same
+// declaration is a different types.Object in every package that imports it.
+// Parsed packages are positioned by the paths they were loaded from and
+// export data by absolute paths, without columns, so the key is made of the
+// absolute path, the line and the name.
+func (ws *goWorkspace) objectKey(obj types.Object) string {
+	p := ws.fset.Position(obj.Pos())
+	if !p.IsValid() {
+		return ""
+	}
+	file, err := filepath.Abs(p.Filename)
+	if err != nil {
+		file = p.Filename
+	}
+	return fmt.Sprintf("%s:%d:%s", file, p.Line, obj.Name())
+}
+
+func GoReferences(input json.RawMessage) (string, error) {
+	symbolInput, ws, err := loadForSymbol(input)
+	if err != nil {
+		return "", err
+	}
+	ws.typeCheck(symbolInput.Path)
+
+	targets := map[string]bool{}
+	var definitions []string
+	for _, obj := range lookupObjects(ws, symbolInput.Name) {
+		key := ws.objectKey(obj)
+		if !targets[key] {
+			targets[key] = true
+			definitions = append(definitions, ws.position(obj.Pos()))
+		}
+	}
+	if len(targets) == 0 {
+		return "", fmt.Errorf("no symbol %s found under %s", symbolInput.Name, symbolInput.Path)
+	}
+
+	type reference struct {
+		file   string
+		line   int
+		column int
+		text   string
+	}
+	var refs []reference
+	seen := map[string]bool{}
+	for _, pkg := range ws.packages {
+		for ident, obj := range pkg.info.Uses {
+			if obj == nil || !targets[ws.objectKey(obj)] {
+				continue
+			}
+			p := ws.fset.Position(ident.Pos())
+			key := fmt.Sprintf("%s:%d:%d", p.Filename, p.Line, p.Column)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			refs = append(refs, reference{file: p.Filename, line: p.Line, column: p.Column, text: sourceLine(p.Filename, p.Line)})
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].file != refs[j].file {
+			return refs[i].file < refs[j].file
+		}
+		if refs[i].line != refs[j].line {
+			return refs[i].line < refs[j].line
+		}
+		return refs[i].column < refs[j].column
+	})
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s is defined at %s\n", symbolInput.Name, strings.Join(definitions, ", "))
+	fmt.Fprintf(&b, "%d references:\n", len(refs))
+	for i, ref := range refs {
+		if i == maxReferences {
+			fmt.Fprintf(&b, "[... %d more references]\n", len(refs)-i)
+			break
+		}
+		fmt.Fprintf(&b, "%s:%d:%d: %s\n", ref.file, ref.line, ref.column, ref.text)
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// packageQualifier writes types from other packages with the package name,
+// as Go source does, and types from pkg unqualified.
+func packageQualifier(pkg *types.Package) types.Qualifier {
+	return func(other *types.Package) string {
+		if other == pkg {
+			return ""
+		}
+		return other.Name()
+	}
+}
+
+// sourceLine returns line n of a file, trimmed, for reference listings.
+func sourceLine(filename string, n int) string {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return ""
+	}
+	lines := strings.Split(string(content), "\n")
+	if n < 1 || n > len(lines) {
+		return ""
+	}
+	return strings.TrimSpace(lines[n-1])
+}
+
+func GoMethods(input json.RawMessage) (string, error) {
+	symbolInput, ws, err := loadForSymbol(input)
+	if err != nil {
+		return "", err
+	}
+	ws.typeCheck(symbolInput.Path)
+
+	var b strings.Builder
+	for _, obj := range lookupObjects(ws, symbolInput.Name) {
+		typeName, ok := obj.(*types.TypeName)
+		if !ok {
+			continue
+		}
+		qualifier := packageQualifier(obj.Pkg())
+		typ := typeName.Type()
+		fmt.Fprintf(&b, "%s (%s)\n", types.TypeString(typ, qualifier), ws.position(obj.Pos()))
+
+		// Methods declared with a value receiver are in both method sets;
+		// the pointer set is the complete list
+		valueSet := types.NewMethodSet(typ)
+		set := valueSet
+		if !types.IsInterface(typ) {
+			set = types.NewMethodSet(types.NewPointer(typ))
+		}
+		if set.Len() == 0 {
+			b.WriteString("  no methods\n")
+		}
+		for i := 0; i < set.Len(); i++ {
+			sel := set.At(i)
+			var notes []string
+			if valueSet.Lookup(sel.Obj().Pkg(), sel.Obj().Name()) == nil {
+				notes = append(notes, "pointer receiver only")
+			}
+			if len(sel.Index()) > 1 {
+				notes = append(notes, "promoted from an embedded field")
+			}
+			line := "  " + types.ObjectString(sel.Obj(), qualifier)
+			if len(notes) > 0 {
+				line += "  [" + strings.Join(notes, ", ") + "]"
+			}
+			fmt.Fprintf(&b, "%s  %s\n", line, ws.position(sel.Obj().Pos()))
+		}
+	}
+	if b.Len() == 0 {
+		return "", fmt.Errorf("no type %s found under %s", symbolInput.Name, symbolInput.Path)
+	}
+	return strings.TrimSpace(b.String()), nil
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// ANSI styles used by the syntax highlighter.
+const (
+	styleKeyword = "\u001b[35m" // magenta
+	styleString  = "\u001b[32m" // green
+	styleNumber  = "\u001b[36m" // cyan
+	styleComment = "\u001b[90m" // gray
+	styleType    = "\u001b[33m" // yellow
+	styleReset   = "\u001b[0m"
+)
+
+// syntax describes just enough of a language to color it: keywords, type
+// names, comment markers and string delimiters.
+type syntax struct {
+	keywords     map[string]bool
+	types        map[string]bool
+	lineComments []string
+	blockComment [2]string
+	quotes       string
+}
+
+func words(s string) map[string]bool {
+	m := map[string]bool{}
+	for _, w := range strings.Fields(s) {
+		m[w] = true
+	}
+	return m
+}
+
+var (
+	cLikeComments = []string{"//"}
+	hashComments  = []string{"#"}
+)
+
+var syntaxes = map[string]*syntax{
+	"go": {
+		keywords:     words("break case chan const continue default defer else fallthrough for func go goto if import interface map package range return select struct switch type var nil true false iota"),
+		types:        words("bool byte complex64 complex128 error float32 float64 int int8 int16 int32 int64 rune string uint uint8 uint16 uint32 uint64 uintptr any"),
+		lineComments: cLikeComments,
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       "\"'`",
+	},
+	"python": {
+		keywords:     words("and as assert async await break class continue def del elif else except finally for from global if import in is lambda nonlocal not or pass raise return try while with yield None True False self"),
+		types:        words("int float str bool list dict set tuple bytes object"),
+		lineComments: hashComments,
+		quotes:       "\"'",
+	},
+	"javascript": {
+		keywords:     words("async await break case catch class const continue debugger default delete do else export extends finally for from function if import in instanceof let new of return super switch this throw try typeof var void while with yield null undefined true false"),
+		types:        words("string number boolean any unknown never void object interface type enum implements"),
+		lineComments: cLikeComments,
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       "\"'`",
+	},
+	"rust": {
+		keywords:     words("as async await break const continue crate else enum extern false fn for if impl in let loop match mod move mut pub ref return self Self static struct super trait true type unsafe use where while"),
+		types:        words("i8 i16 i32 i64 i128 isize u8 u16 u32 u64 u128 usize f32 f64 bool char str String Vec Option Result Box"),
+		lineComments: cLikeComments,
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       "\"",
+	},
+	"c": {
+		keywords:     words("auto break case const continue default do else enum extern for goto if inline register restrict return sizeof static struct switch typedef union volatile while class namespace public private protected template typename new delete this true false nullptr include define"),
+		types:        words("char double float int long short signed unsigned void bool size_t string"),
+		lineComments: cLikeComments,
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       "\"'",
+	},
+	"java": {
+		keywords:     words("abstract assert break case catch class const continue default do else enum extends final finally for if implements import instanceof interface native new package private protected public return static super switch synchronized this throw throws try volatile while true false null"),
+		types:        words("boolean byte char double float int long short void String Object"),
+		lineComments: cLikeComments,
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       "\"'",
+	},
+	"shell": {
+		keywords:     words("if then else elif fi for while until do done case esac function in return export local echo cd exit set"),
+		lineComments: hashComments,
+		quotes:       "\"'",
+	},
+	"json": {
+		keywords: words("true false null"),
+		quotes:   "\"",
+	},
+	"yaml": {
+		keywords:     words("true false null yes no"),
+		lineComments: hashComments,
+		quotes:       "\"'",
+	},
+	"sql": {
+		keywords:     words("SELECT FROM WHERE INSERT INTO VALUES UPDATE SET DELETE CREATE TABLE DROP ALTER JOIN LEFT RIGHT INNER OUTER ON AS AND OR NOT NULL ORDER BY GROUP HAVING LIMIT select from where insert into values update set delete create table drop alter join left right inner outer on as and or not null order by group having limit"),
+		lineComments: []string{"--"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       "'\"",
+	},
+}
+
+var syntaxAliases = map[string]string{
+	"golang": "go", "py": "python", "python3": "python",
+	"js": "javascript", "jsx": "javascript", "ts": "javascript", "tsx": "javascript", "typescript": "javascript",
+	"rs": "rust", "cpp": "c", "c++": "c", "h": "c", "hpp": "c", "cc": "c", "cs": "java", "csharp": "java", "kotlin": "java",
+	"sh": "shell", "bash": "shell", "zsh": "shell", "console": "shell",
+	"yml": "yaml", "jsonc": "json",
+}
+
+func lookupSyntax(lang string) *syntax {
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	if alias, ok := syntaxAliases[lang]; ok {
+		lang = alias
+	}
+	return syntaxes[lang]
+}
+
+// highlightCode colors code written in lang. Unknown languages are returned
+// unchanged. Block comments and strings that span lines are tracked across
+// the whole snippet.
+func highlightCode(code, lang string) string {
+	syn := lookupSyntax(lang)
+	if syn == nil {
+		return code
+	}
+
+	var b strings.Builder
+	inBlock := false
+	i := 0
+	for i < len(code) {
+		rest := code[i:]
+
+		if inBlock || (syn.blockComment[0] != "" && strings.HasPrefix(rest, syn.blockComment[0])) {
+			start := 0
+			if !inBlock {
+				start = len(syn.blockComment[0])
+			}
+			end := strings.Index(rest[start:], syn.blockComment[1])
+			n := len(rest)
+			inBlock = end < 0
+			if end >= 0 {
+				n = start + end + len(syn.blockComment[1])
+			}
+			writeStyledLines(&b, styleComment, rest[:n])
+			i += n
+			continue
+		}
+
+		if prefix := matchPrefix(rest, syn.lineComments); prefix != "" {
+			n := strings.IndexByte(rest, '\n')
+			if n < 0 {
+				n = len(rest)
+			}
+			b.WriteString(styleComment + rest[:n] + styleReset)
+			i += n
+			continue
+		}
+
+		c := rest[0]
+		switch {
+		case strings.IndexByte(syn.quotes, c) >= 0:
+			n := scanString(rest, c)
+			writeStyledLines(&b, styleString, rest[:n])
+			i += n
+		case c >= '0' && c <= '9':
+			n := 1
+			for n < len(rest) && (isWordByte(rest[n]) || rest[n] == '.') {
+				n++
+			}
+			b.WriteString(styleNumber + rest[:n] + styleReset)
+			i += n
+		case isWordByte(c):
+			n := 1
+			for n < len(rest) && isWordByte(rest[n]) {
+				n++
+			}
+			word := rest[:n]
+			switch {
+			case syn.keywords[word]:
+				b.WriteString(styleKeyword + word + styleReset)
+			case syn.types[word]:
+				b.WriteString(styleType + word + styleReset)
+			default:
+				b.WriteString(word)
+			}
+			i += n
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.String()
+}
+
+// writeStyledLines styles s line by line, so that every line of the output
+// can be printed (and indented) on its own.
+func writeStyledLines(b *strings.Builder, style, s string) {
+	for j, line := range strings.Split(s, "\n") {
+		if j > 0 {
+			b.WriteByte('\n')
+		}
+		if line != "" {
+			b.WriteString(style + line + styleReset)
+		}
+	}
+}
+
+func matchPrefix(s string, prefixes []string) string {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return p
+		}
+	}
+	return ""
+}
+
+// scanString returns the length of the string literal at the start of s,
+// honoring backslash escapes except in Go-style raw (backtick) strings.
+// Strings other than raw strings end at the end of the line.
+func scanString(s string, quote byte) int {
+	for n := 1; n < len(s); n++ {
+		switch {
+		case s[n] == '\\' && quote != '`':
+			n++
+		case s[n] == quote:
+			return n + 1
+		case s[n] == '\n' && quote != '`':
+			return n
+		}
+	}
+	return len(s)
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c >= 0x80 || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c))
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// HooksConfig declares shell commands run at points in the agent's
+// lifecycle, so that teams can enforce their rules without changing the
+// agent.
+//
+// Each command receives the event as JSON on stdin. Exiting with status 2
+// blocks what is about to happen, with stderr as the reason; any other
+// non-zero status is reported as a hook failure and otherwise ignored.
+// On success a command may print a hookOutput object to block, replace the
+// tool input or prompt, or add context for the model; plain text printed
+// by a user_prompt_submit hook is added as context.
+type HooksConfig struct {
+	PreToolUse       []Hook `yaml:"pre_tool_use,omitempty"`
+	PostToolUse      []Hook `yaml:"post_tool_use,omitempty"`
+	UserPromptSubmit []Hook `yaml:"user_prompt_submit,omitempty"`
+	SessionEnd       []Hook `yaml:"session_end,omitempty"`
+}
+
+// Hook is one command. Matcher is a regular expression that must match the
+// whole tool name for the command to run on tool events; empty matches
+// every tool.
+type Hook struct {
+	Matcher string        `yaml:"matcher,omitempty"`
+	Command string        `yaml:"command"`
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+}
+
+type HookEvent string
+
+const (
+	HookPreToolUse       HookEvent = "PreToolUse"
+	HookPostToolUse      HookEvent = "PostToolUse"
+	HookUserPromptSubmit HookEvent = "UserPromptSubmit"
+	HookSessionEnd       HookEvent = "SessionEnd"
+)
+
+const (
+	defaultHookTimeout = 60 * time.Second
+	hookBlockExitCode  = 2
+)
+
+func (c HooksConfig) forEvent(event HookEvent) []Hook {
+	switch event {
+	case HookPreToolUse:
+		return c.PreToolUse
+	case HookPostToolUse:
+		return c.PostToolUse
+	case HookUserPromptSubmit:
+		return c.UserPromptSubmit
+	case HookSessionEnd:
+		return c.SessionEnd
+	}
+	return nil
+}
+
+func (c HooksConfig) Validate() error {
+	var errs []error
+	for _, event := range []HookEvent{HookPreToolUse, HookPostToolUse, HookUserPromptSubmit, HookSessionEnd} {
+		for i, hook := range c.forEvent(event) {
+			if hook.Command == "" {
+				errs = append(errs, fmt.Errorf("hooks: %s[%d] needs a command", event, i))
+			}
+			if _, err := regexp.Compile(hook.Matcher); err != nil {
+				errs = append(errs, fmt.Errorf("hooks: %s[%d] has an invalid matcher: %w", event, i, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (h Hook) matches(toolName string) bool {
+	if h.Matcher == "" || toolName == "" {
+		return true
+	}
+	ok, _ := regexp.MatchString("^(?:"+h.Matcher+")$", toolName)
+	return ok
+}
+
+// hookInput is the event a hook reads on stdin.
+type hookInput struct {
+	Event      HookEvent       `json:"event"`
+	SessionID  string          `json:"session_id"`
+	Cwd        string          `json:"cwd"`
+	ToolName   string          `json:"tool_name,omitempty"`
+	ToolInput  json.RawMessage `json:"tool_input,omitempty"`
+	ToolResult *string         `json:"tool_result,omitempty"`
+	IsError    bool            `json:"is_error,omitempty"`
+	Prompt     *string         `json:"prompt,omitempty"`
+	Reason     string          `json:"reason,omitempty"`
+}
+
+// hookOutput is what a hook may print on stdout.
+type hookOutput struct {
+	// Decision "block" stops the tool call or prompt, like exit status 2.
+	Decision string `json:"decision,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+	// ToolInput replaces the input of the tool call (pre_tool_use).
+	ToolInput json.RawMessage `json:"tool_input,omitempty"`
+	// Prompt replaces the user's message (user_prompt_submit).
+	Prompt *string `json:"prompt,omitempty"`
+	// Context is added for the model: to the tool result for tool events,
+	// to the user's message for user_prompt_submit.
+	Context string `json:"context,omitempty"`
+}
+
+// hookResult is the combined outcome of the hooks run for one event.
+type hookResult struct {
+	blocked   bool
+	reason    string
+	toolInput json.RawMessage
+	prompt    *string
+	context   []string
+}
+
+// runHooks runs the hooks for event in order, each seeing the tool input or
+// prompt as changed by the ones before it, and stops at the first that
+// blocks. Failing hooks are reported as errors and skipped.
+func (a *Agent) runHooks(ctx context.Context, event HookEvent, input hookInput) hookResult {
+	var result hookResult
+	input.Event = event
+	input.SessionID = a.sessionID
+	input.Cwd, _ = os.Getwd()
+	if len(input.ToolInput) > 0 && !json.Valid(input.ToolInput) {
+		input.ToolInput, _ = json.Marshal(string(input.ToolInput))
+	}
+
+	for _, hook := range a.hooks.forEvent(event) {
+		if !hook.matches(input.ToolName) {
+			continue
+		}
+		output, blocked, err := hook.run(ctx, input)
+		if err != nil {
+			a.emit(ErrorEvent{Message: fmt.Sprintf("%s hook `%s` failed: %s", event, hook.Command, err)})
+			continue
+		}
+		if blocked || output.Decision == "block" {
+			result.blocked = true
+			result.reason = output.Reason
+			return result
+		}
+		if len(output.ToolInput) > 0 && event == HookPreToolUse {
+			result.toolInput = output.ToolInput
+			input.ToolInput = output.ToolInput
+		}
+		if output.Prompt != nil && event == HookUserPromptSubmit {
+			result.prompt = output.Prompt
+			input.Prompt = output.Prompt
+		}
+		if output.Context != "" {
+			result.context = append(result.context, output.Context)
+		}
+	}
+	return result
+}
+
+// run executes the hook with input on stdin. It reports blocked for exit
+// status 2, with stderr as the reason.
+func (h Hook) run(ctx context.Context, input hookInput) (hookOutput, bool, error) {
+	var output hookOutput
+	data, err := json.Marshal(input)
+	if err != nil {
+		return output, false, err
+	}
+
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = defaultHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := shellCommand(ctx, h.Command)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(), "AGENT_HOOK_EVENT="+string(input.Event), "AGENT_SESSION_ID="+input.SessionID)
+	err = cmd.Run()
+
+	var exitErr *exec.ExitError
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return output, false, fmt.Errorf("timed out after %s", timeout)
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == hookBlockExitCode:
+		output.Reason = strings.TrimSpace(stderr.String())
+		if output.Reason == "" {
+			output.Reason = strings.TrimSpace(stdout.String())
+		}
+		return output, true, nil
+	case err != nil:
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return output, false, fmt.Errorf("%w: %s", err, message)
+		}
+		return output, false, err
+	}
+
+	text := strings.TrimSpace(stdout.String())
+	if strings.HasPrefix(text, "{") {
+		if err := json.Unmarshal([]byte(text), &output); err != nil {
+			return output, false, fmt.Errorf("invalid JSON output: %w", err)
+		}
+	} else if text != "" && input.Event == HookUserPromptSubmit {
+		output.Context = text
+	}
+	return output, false, nil
+}
+
+// hookBlockedMessage explains a blocked tool call to the model.
+func hookBlockedMessage(name, reason string) string {
+	if reason == "" {
+		return fmt.Sprintf("The %s call was blocked by a hook.", name)
+	}
+	return fmt.Sprintf("The %s call was blocked by a hook: %s", name, reason)
+}
+
+// submitPrompt runs the user_prompt_submit hooks on a user message. It
+// returns the message to append, with any context the hooks added, or false
+// if a hook blocked it, in which case the user has been told why.
+func (a *Agent) submitPrompt(ctx context.Context, prompt string) (string, bool) {
+	if len(a.hooks.UserPromptSubmit) == 0 {
+		return prompt, true
+	}
+	result := a.runHooks(ctx, HookUserPromptSubmit, hookInput{Prompt: &prompt})
+	if result.blocked {
+		message := "Your message was blocked by a hook"
+		if result.reason != "" {
+			message += ": " + result.reason
+		}
+		a.emit(ErrorEvent{Message: message})
+		return "", false
+	}
+	if result.prompt != nil {
+		prompt = *result.prompt
+	}
+	for _, context := range result.context {
+		prompt += "\n\n" + context
+	}
+	return prompt, true
+}
+
+// EndSession runs the session_end hooks. reason says why the session ended,
+// such as "exit" or "deleted".
+func (a *Agent) EndSession(reason string) {
+	if len(a.hooks.SessionEnd) == 0 {
+		return
+	}
+	a.runHooks(context.Background(), HookSessionEnd, hookInput{Reason: reason})
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// htmlToMarkdown converts an HTML page to Markdown for the model, keeping
+// the main content and dropping scripts, styles, navigation and other page
+// chrome. Links and images are resolved against base. It also returns the
+// page title.
+func htmlToMarkdown(page string, base *url.URL) (string, string, error) {
+	doc, err := html.Parse(strings.NewReader(page))
+	if err != nil {
+		return "", "", err
+	}
+
+	title := ""
+	if n := findElement(doc, atom.Title); n != nil {
+		title = collapseSpace(textContent(n))
+	}
+	if n := findElement(doc, atom.Base); n != nil {
+		if href, err := url.Parse(attr(n, "href")); err == nil && base != nil {
+			base = base.ResolveReference(href)
+		}
+	}
+
+	// Prefer the element holding the main content, when the page marks it
+	root := findElement(doc, atom.Main)
+	if root == nil {
+		root = findElement(doc, atom.Article)
+	}
+	if root == nil {
+		root = findElement(doc, atom.Body)
+	}
+	if root == nil {
+		root = doc
+	}
+
+	w := &markdownWriter{base: base}
+	w.blocks(root)
+	return w.String(), title, nil
+}
+
+// skippedElements never hold readable content.
+var skippedElements = map[atom.Atom]bool{
+	atom.Head: true, atom.Script: true, atom.Style: true, atom.Noscript: true,
+	atom.Template: true, atom.Svg: true, atom.Math: true, atom.Iframe: true,
+	atom.Object: true, atom.Embed: true, atom.Canvas: true, atom.Button: true,
+	atom.Select: true, atom.Input: true, atom.Textarea: true, atom.Form: true,
+	atom.Nav: true, atom.Footer: true, atom.Aside: true,
+}
+
+// blockElements start a new paragraph.
+var blockElements = map[atom.Atom]bool{
+	atom.Address: true, atom.Article: true, atom.Blockquote: true, atom.Body: true,
+	atom.Center: true, atom.Dd: true, atom.Details: true, atom.Dialog: true,
+	atom.Div: true, atom.Dl: true, atom.Dt: true, atom.Fieldset: true,
+	atom.Figcaption: true, atom.Figure: true, atom.H1: true, atom.H2: true,
+	atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true, atom.Header: true,
+	atom.Hgroup: true, atom.Hr: true, atom.Li: true, atom.Main: true, atom.Ol: true,
+	atom.P: true, atom.Pre: true, atom.Section: true, atom.Summary: true,
+	atom.Table: true, atom.Ul: true, atom.Html: true,
+}
+
+// markdownWriter accumulates Markdown blocks separated by blank lines.
+type markdownWriter struct {
+	base   *url.URL
+	out    []string
+	inline strings.Builder // text of the paragraph being built
+}
+
+func (w *markdownWriter) String() string {
+	w.flush()
+	return strings.Join(w.out, "\n\n")
+}
+
+// flush ends the current paragraph.
+func (w *markdownWriter) flush() {
+	text := cleanInline(w.inline.String())
+	w.inline.Reset()
+	if text != "" {
+		w.out = append(w.out, text)
+	}
+}
+
+func (w *markdownWriter) block(text string) {
+	w.flush()
+	if text = strings.Trim(text, "\n"); strings.TrimSpace(text) != "" {
+		w.out = append(w.out, text)
+	}
+}
+
+// sub renders n's children as blocks of their own, for list items and
+// quotes that indent or prefix them.
+func (w *markdownWriter) sub(n *html.Node) string {
+	inner := &markdownWriter{base: w.base}
+	inner.blocks(n)
+	return inner.String()
+}
+
+// blocks renders the children of n, which may mix block elements and
+// inline content.
+func (w *markdownWriter) blocks(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		w.node(c)
+	}
+}
+
+func (w *markdownWriter) node(n *html.Node) {
+	if n.Type != html.ElementNode || !blockElements[n.DataAtom] {
+		w.inline.WriteString(w.inlineText(n))
+		return
+	}
+	if isHidden(n) {
+		return
+	}
+	switch n.DataAtom {
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		level := int(n.Data[1] - '0')
+		if text := cleanInline(w.inlineText(n)); text != "" {
+			w.block(strings.Repeat("#", level) + " " + strings.ReplaceAll(text, "\n", " "))
+		}
+	case atom.Pre:
+		w.block(fencedCode(n))
+	case atom.Ul, atom.Ol:
+		w.block(w.list(n))
+	case atom.Blockquote:
+		w.block(prefixLines(w.sub(n), "> ", "> "))
+	case atom.Table:
+		w.block(w.table(n))
+	case atom.Hr:
+		w.block("---")
+	case atom.Li:
+		// A list item outside a list
+		w.block(prefixLines(w.sub(n), "- ", "  "))
+	default:
+		w.flush()
+		w.blocks(n)
+		w.flush()
+	}
+}
+
+// isHidden reports whether n is page chrome or not displayed.
+func isHidden(n *html.Node) bool {
+	style := strings.ReplaceAll(attr(n, "style"), " ", "")
+	return skippedElements[n.DataAtom] || hasAttr(n, "hidden") || attr(n, "aria-hidden") == "true" ||
+		strings.Contains(style, "display:none")
+}
+
+// inlineText renders n as inline Markdown.
+func (w *markdownWriter) inlineText(n *html.Node) string {
+	switch n.Type {
+	case html.TextNode:
+		return whitespace.ReplaceAllString(n.Data, " ")
+	case html.ElementNode:
+	default:
+		return ""
+	}
+	if isHidden(n) {
+		return ""
+	}
+
+	switch n.DataAtom {
+	case atom.Br:
+		return "\n"
+	case atom.Img:
+		alt := collapseSpace(attr(n, "alt"))
+		src := w.resolve(attr(n, "src"))
+		if alt == "" || src == "" {
+			return ""
+		}
+		return fmt.Sprintf("![%s](%s)", alt, src)
+	case atom.Code, atom.Kbd, atom.Samp, atom.Tt:
+		text := collapseSpace(textContent(n))
+		if text == "" {
+			return ""
+		}
+		fence := "`"
+		if strings.Contains(text, "`") {
+			fence = "``"
+		}
+		return fence + text + fence
+	}
+
+	// Block elements inside inline ones, like a div in a link, still
+	// start lines of their own
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && blockElements[c.DataAtom] {
+			inner := &markdownWriter{base: w.base}
+			inner.node(c)
+			b.WriteString("\n" + inner.String() + "\n")
+			continue
+		}
+		b.WriteString(w.inlineText(c))
+	}
+	text := b.String()
+
+	switch n.DataAtom {
+	case atom.A:
+		href := attr(n, "href")
+		label := strings.TrimSpace(text)
+		if label == "" || href == "" || strings.HasPrefix(href, "#") || strings.HasPrefix(strings.ToLower(href), "javascript:") {
+			return text
+		}
+		// Link text must stay on one line, even for a link around blocks
+		text = whitespace.ReplaceAllString(text, " ")
+		return wrapInline(text, "[", "]("+w.resolve(href)+")")
+	case atom.Strong, atom.B:
+		return wrapInline(text, "**", "**")
+	case atom.Em, atom.I, atom.Cite:
+		return wrapInline(text, "*", "*")
+	case atom.Del, atom.S, atom.Strike:
+		return wrapInline(text, "~~", "~~")
+	}
+	return text
+}
+
+// wrapInline puts markers around text, keeping its surrounding spaces
+// outside them so that the Markdown stays valid.
+func wrapInline(text, open, close string) string {
+	trimmed := strings.TrimSpace(text)
+	if trimmed == "" {
+		return text
+	}
+	leading := text[:strings.Index(text, trimmed)]
+	trailing := text[len(leading)+len(trimmed):]
+	return leading + open + trimmed + close + trailing
+}
+
+func (w *markdownWriter) list(n *html.Node) string {
+	ordered := n.DataAtom == atom.Ol
+	var items []string
+	number := 1
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode || c.DataAtom != atom.Li {
+			continue
+		}
+		marker := "- "
+		if ordered {
+			marker = fmt.Sprintf("%d. ", number)
+			number++
+		}
+		content := w.sub(c)
+		if content == "" {
+			continue
+		}
+		items = append(items, prefixLines(content, marker, strings.Repeat(" ", len(marker))))
+	}
+	return strings.Join(items, "\n")
+}
+
+func (w *markdownWriter) table(n *html.Node) string {
+	var rows [][]string
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				continue
+			}
+			switch c.DataAtom {
+			case atom.Tr:
+				var row []string
+				for cell := c.FirstChild; cell != nil; cell = cell.NextSibling {
+					if cell.Type == html.ElementNode && (cell.DataAtom == atom.Td || cell.DataAtom == atom.Th) {
+						text := cleanInline(w.inlineText(cell))
+						text = strings.ReplaceAll(strings.ReplaceAll(text, "\n", " "), "|", `\|`)
+						row = append(row, text)
+					}
+				}
+				if len(row) > 0 {
+					rows = append(rows, row)
+				}
+			case atom.Thead, atom.Tbody, atom.Tfoot:
+				walk(c)
+			}
+		}
+	}
+	walk(n)
+	if len(rows) == 0 {
+		return ""
+	}
+
+	columns := 0
+	for _, row := range rows {
+		columns = max(columns, len(row))
+	}
+	var lines []string
+	for i, row := range rows {
+		for len(row) < columns {
+			row = append(row, "")
+		}
+		lines = append(lines, "| "+strings.Join(row, " | ")+" |")
+		if i == 0 {
+			lines = append(lines, "|"+strings.Repeat(" --- |", columns))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// fencedCode renders a pre element as a fenced code block, taking the
+// language from a "language-x" or "lang-x" class.
+func fencedCode(n *html.Node) string {
+	code := strings.Trim(textContent(n), "\n")
+	if strings.TrimSpace(code) == "" {
+		return ""
+	}
+	language := ""
+	for _, node := range []*html.Node{n, findElement(n, atom.Code)} {
+		if node == nil {
+			continue
+		}
+		for _, class := range strings.Fields(attr(node, "class")) {
+			if lang, ok := strings.CutPrefix(class, "language-"); ok {
+				language = lang
+			} else if lang, ok := strings.CutPrefix(class, "lang-"); ok {
+				language = lang
+			}
+		}
+	}
+	fence := "```"
+	for strings.Contains(code, fence) {
+		fence += "`"
+	}
+	return fence + language + "\n" + code + "\n" + fence
+}
+
+func (w *markdownWriter) resolve(href string) string {
+	href = strings.TrimSpace(href)
+	if href == "" || strings.HasPrefix(href, "data:") {
+		return ""
+	}
+	u, err := url.Parse(href)
+	if err != nil || w.base == nil {
+		return href
+	}
+	return w.base.ResolveReference(u).String()
+}
+
+var (
+	whitespace = regexp.MustCompile(`\s+`)
+	spaces     = regexp.MustCompile(` {2,}`)
+	blankLines = regexp.MustCompile(`\n{3,}`)
+)
+
+// cleanInline trims the lines of a paragraph and drops blank runs.
+func cleanInline(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = spaces.ReplaceAllString(strings.TrimSpace(line), " ")
+	}
+	return strings.TrimSpace(blankLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
+
+func collapseSpace(text string) string {
+	return strings.TrimSpace(whitespace.ReplaceAllString(text, " "))
+}
+
+func prefixLines(text, first, rest string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		prefix := rest
+		if i == 0 {
+			prefix = first
+		}
+		if line == "" {
+			prefix = strings.TrimRight(prefix, " ")
+		}
+		lines[i] = prefix + line
+	}
+	return strings.Join(lines, "\n")
+}
+
+// textContent returns the text under n, as written.
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.DataAtom == atom.Br {
+			b.WriteString("\n")
+			continue
+		}
+		b.WriteString(textContent(c))
+	}
+	return b.String()
+}
+
+// findElement returns the first element under n with the given tag.
+func findElement(n *html.Node, tag atom.Atom) *html.Node {
+	if n.Type == html.ElementNode && n.DataAtom == tag {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findElement(c, tag); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func hasAttr(n *html.Node, key string) bool {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"net/http"
+	"os"
+	"regexp"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+var ReadImageDefinition = ToolDefinition{
+	Name:          "read_image",
+	Description:   "Look at an image file (PNG, JPEG, GIF or WebP), such as a screenshot, mockup or diagram. The image is shown to you right after the tool result. Only works when the model accepts images.",
+	InputSchema:   GenerateSchema[ReadImageInput](),
+	ReadOnly:      true,
+	agentFunction: readImage,
+}
+
+type ReadImageInput struct {
+	Path string `json:"path" jsonschema:"required" jsonschema_description:"The relative path of an image file in the working directory."`
+}
+
+// maxImageBytes is the largest image file that is sent to the model; most
+// providers reject bigger ones.
+const maxImageBytes = 20 * 1024 * 1024
+
+var imageMediaTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// visionModels matches the names of well-known models that accept images.
+// The vision config setting overrides the guess.
+var visionModels = regexp.MustCompile(`(?i)vision|gpt-4o|gpt-4\.1|gpt-4-turbo|gpt-5|(^|/)o[134]\b|claude|gemini|llama-4|llava|pixtral|qwen[\w.-]*-vl|gemma-?3|minicpm-v|moondream`)
+
+// supportsVision reports whether the model accepts image content.
+func (a *Agent) supportsVision() bool {
+	if a.vision != nil {
+		return *a.vision
+	}
+	return visionModels.MatchString(a.model)
+}
+
+func (a *Agent) noVisionError() error {
+	return fmt.Errorf("model %s does not accept images; switch to a vision model with -model, or set vision: true in the config if it does", a.model)
+}
+
+// loadImage reads an image file as a message part carrying a data URL, and
+// describes it for the transcript.
+func loadImage(path string) (openai.ChatMessagePart, string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return openai.ChatMessagePart{}, "", err
+	}
+	if info.IsDir() {
+		return openai.ChatMessagePart{}, "", fmt.Errorf("%s is a directory", path)
+	}
+	if info.Size() > maxImageBytes {
+		return openai.ChatMessagePart{}, "", fmt.Errorf("%s is %d MB; images are limited to %d MB", path, info.Size()>>20, maxImageBytes>>20)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return openai.ChatMessagePart{}, "", err
+	}
+
+	mediaType := http.DetectContentType(data)
+	if !imageMediaTypes[mediaType] {
+		return openai.ChatMessagePart{}, "", fmt.Errorf("%s is not a PNG, JPEG, GIF or WebP image (detected %s)", path, mediaType)
+	}
+	description := fmt.Sprintf("%s (%s, %s", path, mediaType, formatSize(len(data)))
+	if config, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+		description += fmt.Sprintf(", %dx%d", config.Width, config.Height)
+	}
+	description += ")"
+
+	part := openai.ChatMessagePart{
+		Type: openai.ChatMessagePartTypeImageURL,
+		ImageURL: &openai.ChatMessageImageURL{
+			URL:    "data:" + mediaType + ";base64," + base64.StdEncoding.EncodeToString(data),
+			Detail: openai.ImageURLDetailAuto,
+		},
+	}
+	return part, description, nil
+}
+
+func formatSize(n int) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.0f KB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d bytes", n)
+}
+
+// readImage loads the image and queues it to be sent after the tool
+// results, since tool messages can only carry text.
+func readImage(ctx context.Context, a *Agent, input json.RawMessage) (string, error) {
+	readInput := ReadImageInput{}
+	if err := json.Unmarshal(input, &readInput); err != nil {
+		return "", err
+	}
+	if !a.supportsVision() {
+		return "", a.noVisionError()
+	}
+	part, description, err := loadImage(readInput.Path)
+	if err != nil {
+		return "", err
+	}
+
+	a.mu.Lock()
+	a.pendingImages = append(a.pendingImages, part)
+	a.mu.Unlock()
+	return fmt.Sprintf("Loaded %s. The image follows in the next message.", description), nil
+}
+
+// flushPendingImages sends the images loaded by read_image during the last
+// round of tool calls as one user message.
+func (a *Agent) flushPendingImages() {
+	a.mu.Lock()
+	images := a.pendingImages
+	a.pendingImages = nil
+	a.mu.Unlock()
+	if len(images) == 0 {
+		return
+	}
+
+	parts := []openai.ChatMessagePart{{
+		Type: openai.ChatMessagePartTypeText,
+		Text: "Images loaded with read_image:",
+	}}
+	a.appendMessage(openai.ChatCompletionMessage{
+		Role:         openai.ChatMessageRoleUser,
+		MultiContent: append(parts, images...),
+	})
+}
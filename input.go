@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+// lineEditor reads REPL input from a terminal with line editing, history,
+// and Tab completion of @-mentioned paths.
+type lineEditor struct {
+	fd   int
+	term *term.Terminal
+
+	// Completions offered by the last Tab; pressing Tab again cycles
+	// through them.
+	matches []string
+	match   int
+}
+
+const (
+	maxCompletionPaths = 50000 // paths walked when completing
+	maxCompletionShown = 10
+)
+
+// newLineEditor returns nil unless both stdin and stdout are terminals.
+func newLineEditor() *lineEditor {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		return nil
+	}
+	e := &lineEditor{fd: fd}
+	e.term = term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}, "")
+	e.term.AutoCompleteCallback = e.complete
+	return e
+}
+
+// SetPrompt sets the prompt shown by the next ReadLine. The editor draws
+// the prompt itself so that it knows where the cursor is.
+func (e *lineEditor) SetPrompt(prompt string) {
+	e.term.SetPrompt(prompt)
+}
+
+// ReadLine reads one line, with the terminal in raw mode only while the
+// user types. Ctrl-C and Ctrl-D end input.
+func (e *lineEditor) ReadLine() (string, bool) {
+	state, err := term.MakeRaw(e.fd)
+	if err != nil {
+		return "", false
+	}
+	defer func() {
+		term.Restore(e.fd, state)
+		e.term.SetPrompt("")
+	}()
+	if width, height, err := term.GetSize(e.fd); err == nil && width > 0 {
+		e.term.SetSize(width, height)
+	}
+
+	line, err := e.term.ReadLine()
+	if err != nil && !errors.Is(err, term.ErrPasteIndicator) {
+		return "", false
+	}
+	return line, true
+}
+
+// complete replaces the @-mention before the cursor with the best matching
+// path on Tab, listing the other matches, which further Tabs cycle through.
+func (e *lineEditor) complete(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' {
+		e.matches = nil
+		return "", 0, false
+	}
+	start := strings.LastIndexAny(line[:pos], " \t") + 1
+	word := line[start:pos]
+	if !strings.HasPrefix(word, "@") {
+		return "", 0, false
+	}
+
+	if len(e.matches) > 0 && word == "@"+e.matches[e.match] {
+		e.match = (e.match + 1) % len(e.matches)
+	} else {
+		e.matches, e.match = completePath(word[1:]), 0
+		if len(e.matches) == 0 {
+			return line, pos, true
+		}
+		if len(e.matches) > 1 {
+			shown := e.matches[:min(len(e.matches), maxCompletionShown)]
+			fmt.Fprintf(e.term, "\u001b[90m%s\u001b[0m\n", strings.Join(shown, "  "))
+		}
+	}
+	replacement := "@" + e.matches[e.match]
+	return line[:start] + replacement + line[pos:], start + len(replacement), true
+}
+
+// completePath returns the paths under the working directory that fuzzily
+// match query, best first. Directories end in a slash. Hidden directories
+// are skipped unless the query names them.
+func completePath(query string) []string {
+	type scored struct {
+		path  string
+		score int
+	}
+	var candidates []scored
+	walked := 0
+	filepath.WalkDir(".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == "." {
+			return nil
+		}
+		walked++
+		if walked > maxCompletionPaths {
+			return filepath.SkipAll
+		}
+		path = filepath.ToSlash(path)
+		if d.IsDir() {
+			if strings.HasPrefix(d.Name(), ".") && !strings.HasPrefix(query, path) {
+				return filepath.SkipDir
+			}
+			path += "/"
+		}
+		if score, ok := fuzzyScore(query, path); ok {
+			candidates = append(candidates, scored{path, score})
+		}
+		return nil
+	})
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].score != candidates[j].score {
+			return candidates[i].score > candidates[j].score
+		}
+		return candidates[i].path < candidates[j].path
+	})
+	paths := make([]string, len(candidates))
+	for i, c := range candidates {
+		paths[i] = c.path
+	}
+	return paths
+}
+
+// fuzzyScore reports whether the characters of query appear in order in
+// path, ignoring case, and scores the match: consecutive characters, matches
+// at the start of a word and in the file name count for more, and shorter
+// paths win ties.
+func fuzzyScore(query, path string) (int, bool) {
+	q, p := strings.ToLower(query), strings.ToLower(path)
+	score, next, previous := 0, 0, -2
+	for _, r := range q {
+		i := strings.IndexRune(p[next:], r)
+		if i < 0 {
+			return 0, false
+		}
+		at := next + i
+		switch {
+		case at == previous+1:
+			score += 5
+		case at == 0 || strings.ContainsRune("/_-. ", rune(p[at-1])):
+			score += 3
+		}
+		score++
+		previous, next = at, at+utf8.RuneLen(r)
+	}
+	if strings.HasPrefix(p, q) {
+		score += 10
+	}
+	if q != "" && strings.Contains(filepath.Base(strings.TrimSuffix(p, "/")), q) {
+		score += 8
+	}
+	return score*1000 - len(p), true
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+type jsonrpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *jsonrpcError   `json:"error,omitempty"`
+
+	// closed is set on the synthetic message a transport delivers when its
+	// connection ends; it is never sent over the wire.
+	closed error
+}
+
+type jsonrpcError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *jsonrpcError) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+const (
+	jsonrpcParseError     = -32700
+	jsonrpcMethodNotFound = -32601
+	jsonrpcInvalidParams  = -32602
+	jsonrpcInternalError  = -32603
+)
+
+// jsonrpcClient is the client side of a JSON-RPC connection, shared by the
+// MCP and language server clients: it numbers requests, hands each response
+// to the call waiting for it, and fails every waiting call once the
+// connection ends. Its owner decides how messages are written, what to do
+// with the requests and notifications the server sends, and how the server
+// is told that a call was abandoned.
+type jsonrpcClient struct {
+	peer   string // names the server in errors, e.g. "mcp server github"
+	write  func(ctx context.Context, msg jsonrpcMessage) error
+	serve  func(msg jsonrpcMessage) // requests and notifications from the server
+	cancel func(id json.RawMessage) // run when a call's context ends first
+
+	nextID  atomic.Int64
+	mu      sync.Mutex
+	pending map[string]chan jsonrpcMessage
+	closed  error
+}
+
+// handle receives every message read from the server, and the message the
+// transport delivers when the connection ends.
+func (c *jsonrpcClient) handle(msg jsonrpcMessage) {
+	if msg.closed != nil {
+		c.mu.Lock()
+		c.closed = msg.closed
+		for id, ch := range c.pending {
+			close(ch)
+			delete(c.pending, id)
+		}
+		c.mu.Unlock()
+		return
+	}
+
+	if msg.Method != "" {
+		if c.serve != nil {
+			c.serve(msg)
+		}
+		return
+	}
+
+	c.mu.Lock()
+	ch, ok := c.pending[string(msg.ID)]
+	delete(c.pending, string(msg.ID))
+	c.mu.Unlock()
+	if ok {
+		ch <- msg
+	}
+}
+
+// err returns why the connection ended, or nil while it is open.
+func (c *jsonrpcClient) err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+func (c *jsonrpcClient) call(ctx context.Context, method string, params any, result any) error {
+	id := json.RawMessage(strconv.FormatInt(c.nextID.Add(1), 10))
+	msg := jsonrpcMessage{JSONRPC: "2.0", ID: id, Method: method}
+	if params != nil {
+		data, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		msg.Params = data
+	}
+
+	ch := make(chan jsonrpcMessage, 1)
+	c.mu.Lock()
+	if c.closed != nil {
+		c.mu.Unlock()
+		return fmt.Errorf("%s: %w", c.peer, c.closed)
+	}
+	if c.pending == nil {
+		c.pending = map[string]chan jsonrpcMessage{}
+	}
+	c.pending[string(id)] = ch
+	c.mu.Unlock()
+
+	if err := c.write(ctx, msg); err != nil {
+		c.forget(id)
+		return err
+	}
+
+	select {
+	case reply, ok := <-ch:
+		if !ok {
+			return fmt.Errorf("%s: %w", c.peer, c.err())
+		}
+		if reply.Error != nil {
+			return reply.Error
+		}
+		if result == nil {
+			return nil
+		}
+		return json.Unmarshal(reply.Result, result)
+	case <-ctx.Done():
+		c.forget(id)
+		if c.cancel != nil {
+			go c.cancel(id)
+		}
+		return ctx.Err()
+	}
+}
+
+func (c *jsonrpcClient) forget(id json.RawMessage) {
+	c.mu.Lock()
+	delete(c.pending, string(id))
+	c.mu.Unlock()
+}
+
+func (c *jsonrpcClient) notify(ctx context.Context, method string, params any) error {
+	msg := jsonrpcMessage{JSONRPC: "2.0", Method: method}
+	if params != nil {
+		data, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		msg.Params = data
+	}
+	return c.write(ctx, msg)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	defaultMaxToolRounds    = 25 // consecutive tool rounds before asking the user
+	defaultMaxRepeatedCalls = 3  // identical calls in a row before asking the user
+)
+
+// toolLoopGuard tracks consecutive tool rounds within a single turn so the
+// agent can pause when the model keeps calling tools without answering.
+type toolLoopGuard struct {
+	maxRounds  int
+	maxRepeats int
+
+	rounds   int
+	repeats  int
+	lastCall string
+}
+
+func newToolLoopGuard(maxRounds, maxRepeats int) *toolLoopGuard {
+	return &toolLoopGuard{
+		maxRounds:  maxRounds,
+		maxRepeats: maxRepeats,
+	}
+}
+
+func (g *toolLoopGuard) reset() {
+	g.rounds = 0
+	g.repeats = 0
+	g.lastCall = ""
+}
+
+// record registers a round of tool calls the model asked for, before they
+// run, and returns a non-empty reason when running them would go past a
+// limit.
+func (g *toolLoopGuard) record(toolCalls []openai.ToolCall) string {
+	g.rounds++
+
+	for _, toolCall := range toolCalls {
+		signature := toolCallSignature(toolCall)
+		if signature == g.lastCall {
+			g.repeats++
+		} else {
+			g.lastCall = signature
+			g.repeats = 1
+		}
+		if g.maxRepeats > 0 && g.repeats >= g.maxRepeats {
+			return fmt.Sprintf("the model called %s with identical arguments %d times in a row", toolCall.Function.Name, g.repeats)
+		}
+	}
+
+	if g.maxRounds > 0 && g.rounds > g.maxRounds {
+		return fmt.Sprintf("the model has run %d tool rounds without replying", g.rounds-1)
+	}
+	return ""
+}
+
+// toolCallSignature identifies a call by name and arguments, normalizing the
+// arguments so that whitespace or key order differences don't hide a repeat.
+func toolCallSignature(toolCall openai.ToolCall) string {
+	args := toolCall.Function.Arguments
+	var decoded any
+	if err := json.Unmarshal([]byte(args), &decoded); err == nil {
+		if normalized, err := json.Marshal(decoded); err == nil {
+			args = string(normalized)
+		}
+	}
+	return toolCall.Function.Name + "(" + args + ")"
+}
+
+type loopDecision int
+
+const (
+	loopContinue loopDecision = iota
+	loopRedirect
+	loopAbort
+)
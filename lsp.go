@@ -0,0 +1,610 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LSPServerConfig declares a language server under lsp_servers in the config
+// file. The server is launched over stdio the first time a file with one of
+// its extensions is used. Env values may reference ${VARS}.
+type LSPServerConfig struct {
+	Command    string            `yaml:"command"`
+	Args       []string          `yaml:"args,omitempty"`
+	Env        map[string]string `yaml:"env,omitempty"`
+	Extensions []string          `yaml:"extensions"`
+	// LanguageID is sent with opened documents; it defaults to a name
+	// derived from the file extension.
+	LanguageID            string         `yaml:"language_id,omitempty"`
+	InitializationOptions map[string]any `yaml:"initialization_options,omitempty"`
+	Disabled              bool           `yaml:"disabled,omitempty"`
+}
+
+// languageIDs maps file extensions to LSP language identifiers where the two
+// differ.
+var languageIDs = map[string]string{
+	".py":  "python",
+	".js":  "javascript",
+	".jsx": "javascriptreact",
+	".ts":  "typescript",
+	".tsx": "typescriptreact",
+	".rs":  "rust",
+	".rb":  "ruby",
+	".cc":  "cpp",
+	".cpp": "cpp",
+	".hpp": "cpp",
+	".h":   "c",
+	".cs":  "csharp",
+	".kt":  "kotlin",
+	".sh":  "shellscript",
+	".md":  "markdown",
+	".yml": "yaml",
+	".txt": "plaintext",
+}
+
+func languageID(cfg LSPServerConfig, path string) string {
+	if cfg.LanguageID != "" {
+		return cfg.LanguageID
+	}
+	ext := strings.ToLower(filepath.Ext(path))
+	if id, ok := languageIDs[ext]; ok {
+		return id
+	}
+	return strings.TrimPrefix(ext, ".")
+}
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"` // in UTF-16 code units
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange        `json:"range"`
+	Severity int             `json:"severity,omitempty"`
+	Code     json.RawMessage `json:"code,omitempty"`
+	Source   string          `json:"source,omitempty"`
+	Message  string          `json:"message"`
+}
+
+const (
+	lspSeverityError   = 1
+	lspSeverityWarning = 2
+)
+
+// lspPublished is the latest diagnostics a server published for a document.
+// seq orders publications across all documents of one server.
+type lspPublished struct {
+	version     int
+	seq         int64
+	diagnostics []lspDiagnostic
+}
+
+// lspClient is a connection to one language server process, speaking
+// JSON-RPC framed with Content-Length headers over its stdin and stdout.
+type lspClient struct {
+	*jsonrpcClient
+	name string
+	cfg  LSPServerConfig
+
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	stderr  *tailBuffer
+	writeMu sync.Mutex
+
+	seq atomic.Int64
+
+	mu        sync.Mutex              // guards everything below
+	documents map[string]*lspDocument // open documents by URI
+	published map[string]lspPublished // by URI
+	updated   chan struct{}           // closed and replaced on every publication
+}
+
+// lspDocument is a file the server was told about with didOpen. syncedSeq
+// is the last publication seen before the current version was sent, so
+// diagnostics published after it can be told apart from older ones.
+type lspDocument struct {
+	version   int
+	text      string
+	syncedSeq int64
+}
+
+// startLSPServer launches the server and performs the initialize handshake
+// with root as the workspace folder.
+func startLSPServer(ctx context.Context, name string, cfg LSPServerConfig, root string, timeout time.Duration) (*lspClient, error) {
+	cmd := exec.Command(cfg.Command, cfg.Args...)
+	cmd.Dir = root
+	cmd.Env = os.Environ()
+	for k, v := range expandEnvMap(cfg.Env) {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+
+	c := &lspClient{
+		name:      name,
+		cfg:       cfg,
+		cmd:       cmd,
+		stderr:    &tailBuffer{limit: 4096},
+		documents: map[string]*lspDocument{},
+		published: map[string]lspPublished{},
+		updated:   make(chan struct{}),
+	}
+	c.jsonrpcClient = &jsonrpcClient{
+		peer:   "language server " + name,
+		write:  c.send,
+		serve:  c.serve,
+		cancel: c.cancelRequest,
+	}
+	cmd.Stderr = c.stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start %s: %w", cfg.Command, err)
+	}
+	c.stdin = stdin
+
+	go func() {
+		readLSPMessages(stdout, c.handle)
+		c.handle(jsonrpcMessage{closed: fmt.Errorf("server exited%s", c.stderr.suffix())})
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	rootURI := fileURI(root)
+	params := map[string]any{
+		"processId":        os.Getpid(),
+		"clientInfo":       map[string]string{"name": "agent", "version": "1.0.0"},
+		"rootUri":          rootURI,
+		"workspaceFolders": []map[string]string{{"uri": rootURI, "name": filepath.Base(root)}},
+		"capabilities": map[string]any{
+			"workspace": map[string]any{
+				"workspaceFolders": true,
+				"configuration":    true,
+				"workspaceEdit":    map[string]any{"documentChanges": true},
+			},
+			"textDocument": map[string]any{
+				"synchronization":    map[string]any{},
+				"publishDiagnostics": map[string]any{"versionSupport": true},
+				"hover":              map[string]any{"contentFormat": []string{"markdown", "plaintext"}},
+				"definition":         map[string]any{"linkSupport": true},
+				"references":         map[string]any{},
+				"rename":             map[string]any{},
+			},
+		},
+	}
+	if cfg.InitializationOptions != nil {
+		params["initializationOptions"] = cfg.InitializationOptions
+	}
+	if err := c.call(ctx, "initialize", params, nil); err != nil {
+		c.kill()
+		return nil, fmt.Errorf("initialize: %w", err)
+	}
+	if err := c.notify(ctx, "initialized", map[string]any{}); err != nil {
+		c.kill()
+		return nil, err
+	}
+	return c, nil
+}
+
+// readLSPMessages decodes Content-Length framed messages until r is
+// exhausted or the framing breaks.
+func readLSPMessages(r io.Reader, deliver func(jsonrpcMessage)) {
+	reader := textproto.NewReader(bufio.NewReader(r))
+	for {
+		header, err := reader.ReadMIMEHeader()
+		if err != nil {
+			return
+		}
+		length, err := strconv.Atoi(header.Get("Content-Length"))
+		if err != nil || length < 0 {
+			return
+		}
+		body := make([]byte, length)
+		if _, err := io.ReadFull(reader.R, body); err != nil {
+			return
+		}
+		var msg jsonrpcMessage
+		if json.Unmarshal(body, &msg) == nil {
+			deliver(msg)
+		}
+	}
+}
+
+// send writes a message with its Content-Length header. Writes to a pipe
+// cannot be abandoned, so ctx is not consulted.
+func (c *lspClient) send(ctx context.Context, msg jsonrpcMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err = fmt.Fprintf(c.stdin, "Content-Length: %d\r\n\r\n%s", len(data), data)
+	return err
+}
+
+// serve receives the server's requests and notifications.
+func (c *lspClient) serve(msg jsonrpcMessage) {
+	if len(msg.ID) == 0 {
+		if msg.Method == "textDocument/publishDiagnostics" {
+			c.publish(msg.Params)
+		}
+		return
+	}
+	go c.send(context.Background(), c.replyTo(msg))
+}
+
+// cancelRequest tells the server to stop working on an abandoned call.
+func (c *lspClient) cancelRequest(id json.RawMessage) {
+	c.notify(context.Background(), "$/cancelRequest", map[string]any{"id": id})
+}
+
+// replyTo answers the requests servers commonly make of their client. The
+// client has no settings to offer, and edits are applied by the agent's own
+// tools, not by the server.
+func (c *lspClient) replyTo(msg jsonrpcMessage) jsonrpcMessage {
+	reply := jsonrpcMessage{JSONRPC: "2.0", ID: msg.ID, Result: json.RawMessage("null")}
+	switch msg.Method {
+	case "workspace/configuration":
+		var params struct {
+			Items []json.RawMessage `json:"items"`
+		}
+		json.Unmarshal(msg.Params, &params)
+		reply.Result, _ = json.Marshal(make([]any, len(params.Items)))
+	case "workspace/applyEdit":
+		reply.Result = json.RawMessage(`{"applied":false}`)
+	case "client/registerCapability", "client/unregisterCapability", "window/workDoneProgress/create", "window/showMessageRequest", "workspace/workspaceFolders":
+	default:
+		reply.Result = nil
+		reply.Error = &jsonrpcError{Code: jsonrpcMethodNotFound, Message: "method not found: " + msg.Method}
+	}
+	return reply
+}
+
+func (c *lspClient) publish(data json.RawMessage) {
+	var params struct {
+		URI         string          `json:"uri"`
+		Version     int             `json:"version,omitempty"`
+		Diagnostics []lspDiagnostic `json:"diagnostics"`
+	}
+	if json.Unmarshal(data, &params) != nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.published[params.URI] = lspPublished{
+		version:     params.Version,
+		seq:         c.seq.Add(1),
+		diagnostics: params.Diagnostics,
+	}
+	close(c.updated)
+	c.updated = make(chan struct{})
+}
+
+// syncFile brings the server's copy of path up to date with the disk,
+// opening, changing or closing the document as needed, and returns its URI.
+func (c *lspClient) syncFile(path string) (string, error) {
+	uri := fileURI(path)
+	content, readErr := os.ReadFile(path)
+
+	// Notifications are sent without holding mu: a large write can block
+	// until the server reads it, and the server may be waiting on us
+	var method string
+	var params map[string]any
+	c.mu.Lock()
+	doc, open := c.documents[uri]
+	switch {
+	case readErr != nil:
+		if open {
+			delete(c.documents, uri)
+			method = "textDocument/didClose"
+			params = map[string]any{"textDocument": map[string]string{"uri": uri}}
+		}
+	case !open:
+		doc = &lspDocument{version: 1, text: string(content), syncedSeq: c.seq.Load()}
+		c.documents[uri] = doc
+		method = "textDocument/didOpen"
+		params = map[string]any{
+			"textDocument": map[string]any{
+				"uri":        uri,
+				"languageId": languageID(c.cfg, path),
+				"version":    doc.version,
+				"text":       doc.text,
+			},
+		}
+	case doc.text != string(content):
+		doc.version++
+		doc.text = string(content)
+		doc.syncedSeq = c.seq.Load()
+		method = "textDocument/didChange"
+		params = map[string]any{
+			"textDocument":   map[string]any{"uri": uri, "version": doc.version},
+			"contentChanges": []map[string]string{{"text": doc.text}},
+		}
+	}
+	c.mu.Unlock()
+
+	if method != "" {
+		if err := c.notify(context.Background(), method, params); err != nil {
+			return "", err
+		}
+	}
+	if readErr != nil && !os.IsNotExist(readErr) {
+		return "", readErr
+	}
+	return uri, nil
+}
+
+// syncOpenFiles re-syncs every open document with the disk.
+func (c *lspClient) syncOpenFiles() error {
+	c.mu.Lock()
+	paths := make([]string, 0, len(c.documents))
+	for uri := range c.documents {
+		paths = append(paths, uriPath(uri))
+	}
+	c.mu.Unlock()
+
+	for _, path := range paths {
+		if _, err := c.syncFile(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitDiagnostics waits until the server has published diagnostics for the
+// synced version of uri, or ctx expires. It returns the latest diagnostics
+// and whether they are known to be current.
+func (c *lspClient) waitDiagnostics(ctx context.Context, uri string) ([]lspDiagnostic, bool) {
+	for {
+		c.mu.Lock()
+		doc, open := c.documents[uri]
+		published, ok := c.published[uri]
+		updated := c.updated
+		c.mu.Unlock()
+
+		if !open {
+			return nil, true
+		}
+		if ok && published.seq > doc.syncedSeq && (published.version == 0 || published.version >= doc.version) {
+			return published.diagnostics, true
+		}
+		select {
+		case <-updated:
+		case <-ctx.Done():
+			return published.diagnostics, false
+		}
+	}
+}
+
+// settle waits until the server has published nothing for quiet, or ctx
+// expires. Servers publish a change's effect on other files in bursts.
+func (c *lspClient) settle(ctx context.Context, quiet time.Duration) {
+	for {
+		c.mu.Lock()
+		updated := c.updated
+		c.mu.Unlock()
+
+		select {
+		case <-updated:
+		case <-time.After(quiet):
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// publishedSince returns the diagnostics published for any document after
+// seq, by URI.
+func (c *lspClient) publishedSince(seq int64) map[string][]lspDiagnostic {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	result := map[string][]lspDiagnostic{}
+	for uri, published := range c.published {
+		if published.seq > seq {
+			result[uri] = published.diagnostics
+		}
+	}
+	return result
+}
+
+// kill stops a server that failed to start properly or crashed, and waits
+// for its process to exit.
+func (c *lspClient) kill() {
+	c.stdin.Close()
+	c.cmd.Process.Kill()
+	c.cmd.Wait()
+}
+
+// Close asks the server to shut down, and kills it if it doesn't exit
+// promptly.
+func (c *lspClient) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if c.call(ctx, "shutdown", nil, nil) == nil {
+		c.notify(ctx, "exit", nil)
+	}
+	c.stdin.Close()
+
+	done := make(chan error, 1)
+	go func() { done <- c.cmd.Wait() }()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(2 * time.Second):
+		c.cmd.Process.Kill()
+		return <-done
+	}
+}
+
+// fileURI returns the file:// URI for path, made absolute.
+func fileURI(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
+
+// uriPath turns a file:// URI back into a path, relative to the working
+// directory when it lies inside it.
+func uriPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return relativePath(filepath.FromSlash(u.Path))
+}
+
+// LSPManager owns the language servers configured under lsp_servers,
+// starting each one the first time a file it handles is used.
+type LSPManager struct {
+	configs    map[string]LSPServerConfig
+	extensions map[string]string // file extension to server name
+	root       string
+	timeout    time.Duration
+
+	mu      sync.Mutex
+	clients map[string]*lspClient
+	failed  map[string]error
+	renames map[string]renamePlan // planned by previewRename, by tool input
+}
+
+// NewLSPManager prepares the enabled servers in configs, or returns nil if
+// there are none. Servers are not started until they are needed.
+func NewLSPManager(configs map[string]LSPServerConfig, timeout time.Duration) *LSPManager {
+	root, err := os.Getwd()
+	if err != nil {
+		root = "."
+	}
+	m := &LSPManager{
+		configs:    map[string]LSPServerConfig{},
+		extensions: map[string]string{},
+		root:       root,
+		timeout:    timeout,
+		clients:    map[string]*lspClient{},
+		failed:     map[string]error{},
+		renames:    map[string]renamePlan{},
+	}
+
+	names := make([]string, 0, len(configs))
+	for name := range configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cfg := configs[name]
+		if cfg.Disabled {
+			continue
+		}
+		m.configs[name] = cfg
+		for _, ext := range cfg.Extensions {
+			ext = strings.ToLower(ext)
+			if !strings.HasPrefix(ext, ".") {
+				ext = "." + ext
+			}
+			if _, taken := m.extensions[ext]; !taken {
+				m.extensions[ext] = name
+			}
+		}
+	}
+	if len(m.configs) == 0 {
+		return nil
+	}
+	return m
+}
+
+// handles reports whether a language server is configured for path.
+func (m *LSPManager) handles(path string) bool {
+	if m == nil {
+		return false
+	}
+	_, ok := m.extensions[strings.ToLower(filepath.Ext(path))]
+	return ok
+}
+
+// clientFor returns the running server for path, starting it if needed. A
+// server that failed to start is not retried.
+func (m *LSPManager) clientFor(ctx context.Context, path string) (*lspClient, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	name, ok := m.extensions[ext]
+	if !ok {
+		known := make([]string, 0, len(m.extensions))
+		for ext := range m.extensions {
+			known = append(known, ext)
+		}
+		sort.Strings(known)
+		return nil, fmt.Errorf("no language server is configured for %s files (configured: %s)", ext, strings.Join(known, ", "))
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if client, ok := m.clients[name]; ok {
+		if client.err() == nil {
+			return client, nil
+		}
+		// Restart a server that crashed since it was last used, reaping the
+		// old process first
+		client.kill()
+		delete(m.clients, name)
+	}
+	if err := m.failed[name]; err != nil {
+		return nil, fmt.Errorf("language server %s: %w", name, err)
+	}
+
+	client, err := startLSPServer(ctx, name, m.configs[name], m.root, m.timeout)
+	if err != nil {
+		m.failed[name] = err
+		return nil, fmt.Errorf("language server %s: %w", name, err)
+	}
+	m.clients[name] = client
+	return client, nil
+}
+
+func (m *LSPManager) Close() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var wg sync.WaitGroup
+	for _, client := range m.clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client.Close()
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,737 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+const (
+	// lspDiagnosticsWait bounds how long a tool waits for a server to
+	// publish diagnostics for a file it was just sent.
+	lspDiagnosticsWait = 5 * time.Second
+	// lspSettleTime is how long the server must stay quiet before the
+	// diagnostics it published for other files are collected.
+	lspSettleTime  = 250 * time.Millisecond
+	maxDiagnostics = 50
+	maxLocations   = 100
+)
+
+type LSPFileInput struct {
+	Path string `json:"path" jsonschema:"required" jsonschema_description:"Relative path of the file to check"`
+}
+
+type LSPPositionInput struct {
+	Path   string `json:"path" jsonschema:"required" jsonschema_description:"Relative path of the file containing the symbol"`
+	Line   int    `json:"line" jsonschema:"required" jsonschema_description:"1-based line number of the symbol"`
+	Symbol string `json:"symbol,omitempty" jsonschema_description:"The symbol as written on that line, used to find its column"`
+	Column int    `json:"column,omitempty" jsonschema_description:"1-based column of the symbol in characters. Only needed when symbol is not given."`
+}
+
+type LSPRenameInput struct {
+	Path    string `json:"path" jsonschema:"required" jsonschema_description:"Relative path of the file containing the symbol"`
+	Line    int    `json:"line" jsonschema:"required" jsonschema_description:"1-based line number of the symbol"`
+	Symbol  string `json:"symbol,omitempty" jsonschema_description:"The symbol as written on that line, used to find its column"`
+	Column  int    `json:"column,omitempty" jsonschema_description:"1-based column of the symbol in characters. Only needed when symbol is not given."`
+	NewName string `json:"new_name" jsonschema:"required" jsonschema_description:"The new name for the symbol"`
+}
+
+// toolDefinitions exposes the language servers to the model. There are no
+// tools when no server is configured.
+func (m *LSPManager) toolDefinitions() []ToolDefinition {
+	if m == nil {
+		return nil
+	}
+	const located = "Locate the symbol by its line and either its name (symbol) or its column."
+	return []ToolDefinition{
+		{
+			Name:        "diagnostics",
+			Description: "Get the errors and warnings a language server reports for a file: compile errors, type errors, lint findings. Use it to check a file you did not just edit; edits report new problems automatically.",
+			InputSchema: GenerateSchema[LSPFileInput](),
+			Function:    m.diagnostics,
+			ReadOnly:    true,
+		},
+		{
+			Name:        "goto_definition",
+			Description: "Find where a symbol used in a file is defined, using a language server. " + located,
+			InputSchema: GenerateSchema[LSPPositionInput](),
+			Function:    m.gotoDefinition,
+			ReadOnly:    true,
+		},
+		{
+			Name:        "find_references",
+			Description: "Find every reference to a symbol across the project, using a language server. " + located,
+			InputSchema: GenerateSchema[LSPPositionInput](),
+			Function:    m.findReferences,
+			ReadOnly:    true,
+		},
+		{
+			Name:        "hover",
+			Description: "Show the type, signature and documentation of a symbol, using a language server. " + located,
+			InputSchema: GenerateSchema[LSPPositionInput](),
+			Function:    m.hover,
+			ReadOnly:    true,
+		},
+		{
+			Name:             "rename_symbol",
+			Description:      "Rename a symbol and update every reference to it across the project, using a language server. All files are changed together or not at all. " + located,
+			InputSchema:      GenerateSchema[LSPRenameInput](),
+			Function:         m.renameSymbol,
+			Preview:          m.previewRename,
+			editsUnreadFiles: true,
+		},
+	}
+}
+
+func (m *LSPManager) diagnostics(input json.RawMessage) (string, error) {
+	fileInput := LSPFileInput{}
+	if err := json.Unmarshal(input, &fileInput); err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(fileInput.Path); err != nil {
+		return "", err
+	}
+
+	client, err := m.clientFor(context.Background(), fileInput.Path)
+	if err != nil {
+		return "", err
+	}
+	uri, err := client.syncFile(fileInput.Path)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), lspDiagnosticsWait)
+	defer cancel()
+	diagnostics, current := client.waitDiagnostics(ctx, uri)
+
+	var b strings.Builder
+	writeDiagnostics(&b, fileInput.Path, diagnostics, sourceCache{})
+	if b.Len() == 0 {
+		b.WriteString("No problems reported for " + fileInput.Path + ".\n")
+	}
+	if !current {
+		b.WriteString("(the language server has not finished checking the latest version; results may be incomplete)\n")
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// diagnosticsAfterWrite syncs files a tool just wrote and reports the
+// problems the language servers find in them, plus errors that appeared in
+// other files, so the model can fix them right away. It returns "" when
+// there is nothing to report.
+func (m *LSPManager) diagnosticsAfterWrite(paths []string) string {
+	if m == nil {
+		return ""
+	}
+
+	type syncedFile struct {
+		client *lspClient
+		path   string
+		uri    string
+	}
+	var files []syncedFile
+	since := map[*lspClient]int64{}
+	for _, path := range paths {
+		if !m.handles(path) {
+			continue
+		}
+		client, err := m.clientFor(context.Background(), path)
+		if err != nil {
+			continue
+		}
+		if _, ok := since[client]; !ok {
+			since[client] = client.seq.Load()
+		}
+		uri, err := client.syncFile(path)
+		if err != nil {
+			continue
+		}
+		files = append(files, syncedFile{client: client, path: path, uri: uri})
+	}
+	if len(files) == 0 {
+		return ""
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), lspDiagnosticsWait)
+	defer cancel()
+	var b strings.Builder
+	cache := sourceCache{}
+	reported := map[string]bool{}
+	for _, file := range files {
+		diagnostics, _ := file.client.waitDiagnostics(ctx, file.uri)
+		writeDiagnostics(&b, file.path, filterDiagnostics(diagnostics, lspSeverityWarning), cache)
+		reported[file.uri] = true
+	}
+
+	// A change often breaks other files, such as the callers of a function
+	for client, seq := range since {
+		client.settle(ctx, lspSettleTime)
+		published := client.publishedSince(seq)
+		uris := make([]string, 0, len(published))
+		for uri := range published {
+			uris = append(uris, uri)
+		}
+		sort.Strings(uris)
+		for _, uri := range uris {
+			if !reported[uri] {
+				writeDiagnostics(&b, uriPath(uri), filterDiagnostics(published[uri], lspSeverityError), cache)
+			}
+		}
+	}
+
+	if b.Len() == 0 {
+		return ""
+	}
+	return "The language server reports these problems after the change:\n" + strings.TrimSpace(b.String())
+}
+
+// filterDiagnostics keeps diagnostics at least as severe as severity.
+// Servers that leave the severity out are taken to report errors.
+func filterDiagnostics(diagnostics []lspDiagnostic, severity int) []lspDiagnostic {
+	var kept []lspDiagnostic
+	for _, d := range diagnostics {
+		if d.Severity <= severity {
+			kept = append(kept, d)
+		}
+	}
+	return kept
+}
+
+func writeDiagnostics(b *strings.Builder, path string, diagnostics []lspDiagnostic, cache sourceCache) {
+	diagnostics = slices.Clone(diagnostics)
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		if diagnostics[i].Range.Start.Line != diagnostics[j].Range.Start.Line {
+			return diagnostics[i].Range.Start.Line < diagnostics[j].Range.Start.Line
+		}
+		return diagnostics[i].Range.Start.Character < diagnostics[j].Range.Start.Character
+	})
+	for i, d := range diagnostics {
+		if i == maxDiagnostics {
+			fmt.Fprintf(b, "[... %d more in %s]\n", len(diagnostics)-i, path)
+			break
+		}
+		line, column := cache.lineColumn(path, d.Range.Start)
+		fmt.Fprintf(b, "%s:%d:%d: %s: %s", path, line, column, severityName(d.Severity), strings.ReplaceAll(d.Message, "\n", " "))
+		if d.Source != "" {
+			fmt.Fprintf(b, " (%s)", d.Source)
+		}
+		b.WriteString("\n")
+	}
+}
+
+func severityName(severity int) string {
+	switch severity {
+	case 2:
+		return "warning"
+	case 3:
+		return "info"
+	case 4:
+		return "hint"
+	default:
+		return "error"
+	}
+}
+
+// positionParams starts the server for the input's file, syncs the file and
+// returns the request parameters locating the symbol.
+func (m *LSPManager) positionParams(ctx context.Context, in LSPPositionInput) (*lspClient, map[string]any, error) {
+	content, err := os.ReadFile(in.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+	position, err := in.position(string(content))
+	if err != nil {
+		return nil, nil, err
+	}
+	client, err := m.clientFor(ctx, in.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Cross-file requests such as references and rename rely on the
+	// server's view of every open file
+	if err := client.syncOpenFiles(); err != nil {
+		return nil, nil, err
+	}
+	uri, err := client.syncFile(in.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+	params := map[string]any{
+		"textDocument": map[string]string{"uri": uri},
+		"position":     position,
+	}
+	return client, params, nil
+}
+
+// position converts the input's 1-based line and its symbol or character
+// column into an LSP position within content.
+func (in LSPPositionInput) position(content string) (lspPosition, error) {
+	lines := strings.Split(content, "\n")
+	if in.Line < 1 || in.Line > len(lines) {
+		return lspPosition{}, fmt.Errorf("line %d is out of range; %s has %d lines", in.Line, in.Path, len(lines))
+	}
+	text := strings.TrimSuffix(lines[in.Line-1], "\r")
+
+	var prefix string
+	switch {
+	case in.Symbol != "":
+		i := indexSymbol(text, in.Symbol)
+		if i < 0 {
+			return lspPosition{}, fmt.Errorf("%q does not appear on line %d of %s: %s", in.Symbol, in.Line, in.Path, strings.TrimSpace(text))
+		}
+		prefix = text[:i]
+	case in.Column >= 1:
+		runes := []rune(text)
+		if in.Column > len(runes)+1 {
+			return lspPosition{}, fmt.Errorf("column %d is past the end of line %d, which has %d characters", in.Column, in.Line, len(runes))
+		}
+		prefix = string(runes[:in.Column-1])
+	default:
+		return lspPosition{}, fmt.Errorf("give the symbol's name or its column")
+	}
+	return lspPosition{Line: in.Line - 1, Character: len(utf16.Encode([]rune(prefix)))}, nil
+}
+
+// indexSymbol finds symbol in line, preferring an occurrence that is a
+// whole word over one inside a longer identifier.
+func indexSymbol(line, symbol string) int {
+	isIdent := func(r rune) bool { return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) }
+	first := -1
+	for offset := 0; offset <= len(line); {
+		i := strings.Index(line[offset:], symbol)
+		if i < 0 {
+			break
+		}
+		i += offset
+		if first < 0 {
+			first = i
+		}
+		before, _ := utf8.DecodeLastRuneInString(line[:i])
+		after, _ := utf8.DecodeRuneInString(line[i+len(symbol):])
+		if (i == 0 || !isIdent(before)) && (i+len(symbol) == len(line) || !isIdent(after)) {
+			return i
+		}
+		offset = i + 1
+	}
+	return first
+}
+
+// sourceCache holds the lines of files shown in results, so that LSP
+// positions can be turned into character columns and context lines.
+type sourceCache map[string][]string
+
+func (sc sourceCache) lines(path string) []string {
+	if lines, ok := sc[path]; ok {
+		return lines
+	}
+	content, _ := os.ReadFile(path)
+	lines := strings.Split(string(content), "\n")
+	sc[path] = lines
+	return lines
+}
+
+// lineColumn converts an LSP position into a 1-based line and character
+// column.
+func (sc sourceCache) lineColumn(path string, pos lspPosition) (int, int) {
+	lines := sc.lines(path)
+	if pos.Line >= len(lines) {
+		return pos.Line + 1, pos.Character + 1
+	}
+	units := 0
+	column := 1
+	for _, r := range lines[pos.Line] {
+		if units >= pos.Character {
+			break
+		}
+		units += len(utf16.Encode([]rune{r}))
+		column++
+	}
+	return pos.Line + 1, column
+}
+
+func (sc sourceCache) text(path string, line int) string {
+	lines := sc.lines(path)
+	if line < 0 || line >= len(lines) {
+		return ""
+	}
+	return strings.TrimSpace(lines[line])
+}
+
+func (sc sourceCache) formatLocation(location lspLocation) string {
+	path := uriPath(location.URI)
+	line, column := sc.lineColumn(path, location.Range.Start)
+	return fmt.Sprintf("%s:%d:%d: %s", path, line, column, sc.text(path, location.Range.Start.Line))
+}
+
+// parseLocations decodes a Location, a Location array or a LocationLink
+// array, which servers may return interchangeably.
+func parseLocations(raw json.RawMessage) ([]lspLocation, error) {
+	type locationOrLink struct {
+		lspLocation
+		TargetURI            string    `json:"targetUri"`
+		TargetSelectionRange *lspRange `json:"targetSelectionRange"`
+	}
+	var items []locationOrLink
+	trimmed := strings.TrimSpace(string(raw))
+	switch {
+	case trimmed == "" || trimmed == "null":
+		return nil, nil
+	case strings.HasPrefix(trimmed, "["):
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return nil, err
+		}
+	default:
+		var item locationOrLink
+		if err := json.Unmarshal(raw, &item); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	locations := make([]lspLocation, 0, len(items))
+	for _, item := range items {
+		if item.TargetURI != "" && item.TargetSelectionRange != nil {
+			locations = append(locations, lspLocation{URI: item.TargetURI, Range: *item.TargetSelectionRange})
+		} else {
+			locations = append(locations, item.lspLocation)
+		}
+	}
+	return locations, nil
+}
+
+func formatLocations(locations []lspLocation) string {
+	sort.SliceStable(locations, func(i, j int) bool {
+		a, b := locations[i], locations[j]
+		if a.URI != b.URI {
+			return a.URI < b.URI
+		}
+		if a.Range.Start.Line != b.Range.Start.Line {
+			return a.Range.Start.Line < b.Range.Start.Line
+		}
+		return a.Range.Start.Character < b.Range.Start.Character
+	})
+	var b strings.Builder
+	cache := sourceCache{}
+	for i, location := range locations {
+		if i == maxLocations {
+			fmt.Fprintf(&b, "[... %d more]\n", len(locations)-i)
+			break
+		}
+		b.WriteString(cache.formatLocation(location) + "\n")
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// positionRequest decodes a position input and sends method for it.
+func (m *LSPManager) positionRequest(input json.RawMessage, method string, extra map[string]any) (LSPPositionInput, json.RawMessage, error) {
+	positionInput := LSPPositionInput{}
+	if err := json.Unmarshal(input, &positionInput); err != nil {
+		return positionInput, nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+	client, params, err := m.positionParams(ctx, positionInput)
+	if err != nil {
+		return positionInput, nil, err
+	}
+	for k, v := range extra {
+		params[k] = v
+	}
+	var result json.RawMessage
+	if err := client.call(ctx, method, params, &result); err != nil {
+		return positionInput, nil, fmt.Errorf("%s: %w", method, err)
+	}
+	return positionInput, result, nil
+}
+
+func (m *LSPManager) gotoDefinition(input json.RawMessage) (string, error) {
+	positionInput, result, err := m.positionRequest(input, "textDocument/definition", nil)
+	if err != nil {
+		return "", err
+	}
+	locations, err := parseLocations(result)
+	if err != nil {
+		return "", err
+	}
+	if len(locations) == 0 {
+		return "", fmt.Errorf("no definition found for the symbol at %s:%d", positionInput.Path, positionInput.Line)
+	}
+	return formatLocations(locations), nil
+}
+
+func (m *LSPManager) findReferences(input json.RawMessage) (string, error) {
+	positionInput, result, err := m.positionRequest(input, "textDocument/references", map[string]any{
+		"context": map[string]bool{"includeDeclaration": true},
+	})
+	if err != nil {
+		return "", err
+	}
+	locations, err := parseLocations(result)
+	if err != nil {
+		return "", err
+	}
+	if len(locations) == 0 {
+		return "", fmt.Errorf("no references found for the symbol at %s:%d", positionInput.Path, positionInput.Line)
+	}
+	return fmt.Sprintf("%d references (including the declaration):\n%s", len(locations), formatLocations(locations)), nil
+}
+
+func (m *LSPManager) hover(input json.RawMessage) (string, error) {
+	positionInput, result, err := m.positionRequest(input, "textDocument/hover", nil)
+	if err != nil {
+		return "", err
+	}
+	var hover struct {
+		Contents json.RawMessage `json:"contents"`
+	}
+	if string(result) != "null" {
+		if err := json.Unmarshal(result, &hover); err != nil {
+			return "", err
+		}
+	}
+	text := strings.TrimSpace(hoverText(hover.Contents))
+	if text == "" {
+		return "", fmt.Errorf("no information available for the symbol at %s:%d", positionInput.Path, positionInput.Line)
+	}
+	return text, nil
+}
+
+// hoverText flattens hover contents, which may be MarkupContent, a
+// MarkedString or an array of MarkedStrings.
+func hoverText(raw json.RawMessage) string {
+	var text string
+	if json.Unmarshal(raw, &text) == nil {
+		return text
+	}
+	var markup struct {
+		Language string `json:"language"`
+		Value    string `json:"value"`
+	}
+	if json.Unmarshal(raw, &markup) == nil && markup.Value != "" {
+		if markup.Language != "" {
+			return "```" + markup.Language + "\n" + markup.Value + "\n```"
+		}
+		return markup.Value
+	}
+	var parts []json.RawMessage
+	if json.Unmarshal(raw, &parts) == nil {
+		texts := make([]string, 0, len(parts))
+		for _, part := range parts {
+			texts = append(texts, hoverText(part))
+		}
+		return strings.Join(texts, "\n\n")
+	}
+	return ""
+}
+
+type lspTextEdit struct {
+	Range   lspRange `json:"range"`
+	NewText string   `json:"newText"`
+}
+
+// lspWorkspaceEdit is the result of a rename: text edits keyed by document,
+// in either of the two forms the protocol allows.
+type lspWorkspaceEdit struct {
+	Changes         map[string][]lspTextEdit `json:"changes"`
+	DocumentChanges []struct {
+		Kind         string `json:"kind"`
+		TextDocument struct {
+			URI string `json:"uri"`
+		} `json:"textDocument"`
+		Edits []lspTextEdit `json:"edits"`
+	} `json:"documentChanges"`
+}
+
+// planRename asks the server for the edits renaming a symbol and applies
+// them in memory.
+func (m *LSPManager) planRename(input json.RawMessage) ([]*plannedFile, LSPRenameInput, int, error) {
+	renameInput := LSPRenameInput{}
+	if err := json.Unmarshal(input, &renameInput); err != nil {
+		return nil, renameInput, 0, err
+	}
+	if strings.TrimSpace(renameInput.NewName) == "" {
+		return nil, renameInput, 0, fmt.Errorf("new_name cannot be empty")
+	}
+
+	positionInput := LSPPositionInput{Path: renameInput.Path, Line: renameInput.Line, Symbol: renameInput.Symbol, Column: renameInput.Column}
+	positionJSON, _ := json.Marshal(positionInput)
+	_, result, err := m.positionRequest(positionJSON, "textDocument/rename", map[string]any{"newName": renameInput.NewName})
+	if err != nil {
+		return nil, renameInput, 0, err
+	}
+
+	var workspaceEdit lspWorkspaceEdit
+	if err := json.Unmarshal(result, &workspaceEdit); err != nil {
+		return nil, renameInput, 0, err
+	}
+	edits := map[string][]lspTextEdit{}
+	for uri, textEdits := range workspaceEdit.Changes {
+		edits[uri] = append(edits[uri], textEdits...)
+	}
+	for _, change := range workspaceEdit.DocumentChanges {
+		if change.Kind != "" {
+			return nil, renameInput, 0, fmt.Errorf("the rename would %s files, which rename_symbol does not support; use rename_file and edit_file instead", change.Kind)
+		}
+		edits[change.TextDocument.URI] = append(edits[change.TextDocument.URI], change.Edits...)
+	}
+	if len(edits) == 0 {
+		return nil, renameInput, 0, fmt.Errorf("the language server found nothing to rename at %s:%d", renameInput.Path, renameInput.Line)
+	}
+
+	uris := make([]string, 0, len(edits))
+	for uri := range edits {
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+
+	var files []*plannedFile
+	count := 0
+	for _, uri := range uris {
+		path := uriPath(uri)
+		original, err := os.ReadFile(path)
+		if err != nil {
+			return nil, renameInput, 0, err
+		}
+		content, err := applyTextEdits(string(original), edits[uri])
+		if err != nil {
+			return nil, renameInput, 0, fmt.Errorf("%s: %w", path, err)
+		}
+		files = append(files, &plannedFile{path: path, existed: true, original: original, content: content})
+		count += len(edits[uri])
+	}
+	return files, renameInput, count, nil
+}
+
+// applyTextEdits applies LSP text edits, whose ranges all refer to the
+// original content. Edits at the same position keep their order.
+func applyTextEdits(content string, edits []lspTextEdit) (string, error) {
+	type span struct {
+		start, end int
+		text       string
+	}
+	spans := make([]span, len(edits))
+	for i, edit := range edits {
+		spans[i] = span{byteOffset(content, edit.Range.Start), byteOffset(content, edit.Range.End), edit.NewText}
+		if spans[i].end < spans[i].start {
+			return "", fmt.Errorf("invalid edit range")
+		}
+	}
+	sort.SliceStable(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+
+	var b strings.Builder
+	last := 0
+	for _, s := range spans {
+		if s.start < last {
+			return "", fmt.Errorf("the language server returned overlapping edits")
+		}
+		b.WriteString(content[last:s.start])
+		b.WriteString(s.text)
+		last = s.end
+	}
+	b.WriteString(content[last:])
+	return b.String(), nil
+}
+
+// byteOffset converts an LSP position into an offset in content, clamping
+// positions past the end of a line or of the file.
+func byteOffset(content string, pos lspPosition) int {
+	offset := 0
+	for line := 0; line < pos.Line; line++ {
+		i := strings.IndexByte(content[offset:], '\n')
+		if i < 0 {
+			return len(content)
+		}
+		offset += i + 1
+	}
+	units := 0
+	for offset < len(content) && content[offset] != '\n' && units < pos.Character {
+		r, size := utf8.DecodeRuneInString(content[offset:])
+		units += len(utf16.Encode([]rune{r}))
+		offset += size
+	}
+	return offset
+}
+
+// maxPendingRenames bounds the plans kept for rename calls that were
+// previewed but never run.
+const maxPendingRenames = 16
+
+// renamePlan is a rename worked out by previewRename, kept so that exactly
+// the changes shown for approval are written.
+type renamePlan struct {
+	files []*plannedFile
+	input LSPRenameInput
+	count int
+}
+
+func (m *LSPManager) renameSymbol(input json.RawMessage) (string, error) {
+	m.mu.Lock()
+	plan, ok := m.renames[string(input)]
+	delete(m.renames, string(input))
+	m.mu.Unlock()
+	if !ok {
+		var err error
+		plan.files, plan.input, plan.count, err = m.planRename(input)
+		if err != nil {
+			return "", err
+		}
+	}
+	files, renameInput, count := plan.files, plan.input, plan.count
+
+	// The plan holds whole files, so writing it over a file that changed
+	// since would undo that change
+	for _, file := range files {
+		current, err := os.ReadFile(file.path)
+		if err != nil {
+			return "", err
+		}
+		if !bytes.Equal(current, file.original) {
+			return "", fmt.Errorf("%s changed after the rename was planned; nothing was renamed, try again", file.path)
+		}
+	}
+
+	var report []string
+	for i, file := range files {
+		if err := writePlannedFile(file); err != nil {
+			if rollbackErr := rollbackPlannedFiles(files[:i]); rollbackErr != nil {
+				return "", fmt.Errorf("failed to write %s: %v; restoring the files already written also failed: %v", file.path, err, rollbackErr)
+			}
+			return "", fmt.Errorf("failed to write %s: %v; the %d files already written were restored", file.path, err, i)
+		}
+		report = append(report, file.path)
+	}
+	return fmt.Sprintf("Renamed to %s with %d edits in %d files:\n%s", renameInput.NewName, count, len(files), strings.Join(report, "\n")), nil
+}
+
+func (m *LSPManager) previewRename(input json.RawMessage) ([]FileChange, error) {
+	// A rename that fails to plan writes nothing, whatever the reason
+	files, renameInput, count, err := m.planRename(input)
+	if err != nil {
+		return nil, invalidInput(err)
+	}
+	m.mu.Lock()
+	if len(m.renames) >= maxPendingRenames {
+		clear(m.renames) // plans of rejected calls
+	}
+	m.renames[string(input)] = renamePlan{files: files, input: renameInput, count: count}
+	m.mu.Unlock()
+	changes := make([]FileChange, 0, len(files))
+	for _, file := range files {
+		change, err := newFileChange(file.path, file.content)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, *change)
+	}
+	return changes, nil
+}
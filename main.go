@@ -2,15 +2,24 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/sashabaranov/go-openai"
 	"github.com/invopop/jsonschema"
@@ -21,114 +30,389 @@ type ToolDefinition struct {
 	Description string                     `json:"description"`
 	InputSchema any                        `json:"parameters"`
 	Function    func(input json.RawMessage) (string, error)
+	// Preview, if set, describes the file changes a call would make
+	// without making them, for diff display and review.
+	Preview func(input json.RawMessage) ([]FileChange, error)
+	// ReadOnly tools leave files and the system untouched and stay
+	// available in plan mode.
+	ReadOnly bool
+
+	// agentFunction replaces Function for tools that act on the agent
+	// running them, such as delegation to sub-agents.
+	agentFunction func(ctx context.Context, a *Agent, input json.RawMessage) (string, error)
+	// editsUnreadFiles marks tools whose changes are computed from the
+	// files on disk rather than from what the model has read, so they may
+	// touch files the model has not seen.
+	editsUnreadFiles bool
 }
 
 func main() {
-	config := openai.DefaultConfig(os.Getenv("GROQ_API_KEY"))
-	config.BaseURL = "https://api.groq.com/openai/v1"
-	client := openai.NewClientWithConfig(config)
+	flags := newConfigFlags(os.Args[0])
+	if err := flags.set.Parse(os.Args[1:]); err != nil {
+		os.Exit(2)
+	}
+
+	cfg, err := LoadConfig(flags)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		os.Exit(1)
+	}
 
+	if args := flags.set.Args(); len(args) > 0 {
+		switch {
+		case len(args) == 2 && args[0] == "config" && args[1] == "show":
+			if _, err := cfg.WriteTo(os.Stdout); err != nil {
+				fmt.Printf("Error: %s\n", err.Error())
+				os.Exit(1)
+			}
+		case args[0] == "serve":
+			if err := runServe(cfg, args[1:]); err != nil {
+				fmt.Printf("Error: %s\n", err.Error())
+				os.Exit(1)
+			}
+		case args[0] == "mcp-serve":
+			if err := runMCPServe(cfg, args[1:]); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+				os.Exit(1)
+			}
+		default:
+			fmt.Printf("Error: unknown command %q\n", strings.Join(args, " "))
+			os.Exit(2)
+		}
+		return
+	}
+
+	client := newClient(cfg)
+
+	editor := newLineEditor()
 	scanner := bufio.NewScanner(os.Stdin)
 	getUserMessage := func() (string, bool) {
+		if editor != nil {
+			return editor.ReadLine()
+		}
 		if !scanner.Scan() {
 			return "", false
 		}
 		return scanner.Text(), true
 	}
 
-	tools := []ToolDefinition{
-		ReadFileDefinition,
-		ListFilesDefinition,
-		EditFileDefinition,
-		CreateFileDefinition,
-		DeleteFileDefinition,
-		RenameFileDefinition,
-		CreateFolderDefinition,
-		DeleteFolderDefinition,
-		RenameFolderDefinition,
-		TerminalRunDefinition,
-		CreateWebsiteDefinition,
-	}
+	terminalRunTimeout = cfg.Timeouts.TerminalRun
+
+	mcp, mcpTools, mcpErrs := ConnectMCPServers(context.TODO(), cfg.MCPServers, cfg.Timeouts.MCP)
+	defer mcp.Close()
+	lsp := NewLSPManager(cfg.LSPServers, cfg.Timeouts.LSP)
+	defer lsp.Close()
+
+	web := NewWebClient(cfg.Web, cfg.Timeouts.WebFetch)
+
+	tools := cfg.FilterTools(slices.Concat(allTools, web.toolDefinitions(), lsp.toolDefinitions(), mcpTools))
 	agent := NewAgent(client, getUserMessage, tools)
-	err := agent.Run(context.TODO())
+	agent.applyConfig(cfg)
+	agent.lsp = lsp
+	if cfg.Output.Format == "json" {
+		// Keep stdout machine-readable; questions for the user go to stderr
+		agent.sink = NewJSONLinesRenderer(os.Stdout)
+		agent.approver = NewTerminalApprover(os.Stderr, getUserMessage)
+	} else {
+		if editor != nil {
+			renderer := NewTerminalRenderer(os.Stdout)
+			renderer.prompt = editor.SetPrompt
+			agent.sink = renderer
+		}
+		fmt.Println("Chat with Groq (use 'ctrl-c' to quit, tab to complete @paths)")
+	}
+	for _, err := range mcpErrs {
+		agent.emit(ErrorEvent{Message: err.Error()})
+	}
+	err = agent.Run(context.TODO())
+	agent.EndSession("exit")
 	if err != nil {
 		fmt.Printf("Error: %s\n", err.Error())
 	}
 }
 
+func newClient(cfg Config) *openai.Client {
+	config := openai.DefaultConfig(cfg.APIKey())
+	config.BaseURL = cfg.Provider.BaseURL
+	config.HTTPClient = &http.Client{Transport: retryAfterTransport{base: http.DefaultTransport}}
+	return openai.NewClientWithConfig(config)
+}
+
+// allTools is every built-in tool; the config's tool lists pick from it.
+var allTools = []ToolDefinition{
+	ReadFileDefinition,
+	ReadImageDefinition,
+	ListFilesDefinition,
+	SearchFilesDefinition,
+	GoOutlineDefinition,
+	GoSymbolsDefinition,
+	GoDefinitionDefinition,
+	GoReferencesDefinition,
+	GoMethodsDefinition,
+	EditFileDefinition,
+	MultiEditDefinition,
+	CreateFileDefinition,
+	DeleteFileDefinition,
+	RenameFileDefinition,
+	CreateFolderDefinition,
+	DeleteFolderDefinition,
+	RenameFolderDefinition,
+	TerminalRunDefinition,
+	RunTestsDefinition,
+	CreateWebsiteDefinition,
+	DelegateTaskDefinition,
+	TodoWriteDefinition,
+	TodoReadDefinition,
+}
+
 func NewAgent(
 	client *openai.Client,
 	getUserMessage func() (string, bool),
 	tools []ToolDefinition,
 ) *Agent {
-	return &Agent{
+	agent := &Agent{
 		client:         client,
 		getUserMessage: getUserMessage,
 		tools:          tools,
+		sink:           NewTerminalRenderer(os.Stdout),
+		approver:       NewTerminalApprover(os.Stdout, getUserMessage),
+		sessionID:      newSessionID(),
 	}
-}
+	agent.applyConfig(DefaultConfig())
+	return agent
+}
+
+// applyConfig copies the agent-level settings from cfg. Provider and tool
+// selection are handled by the caller when building the client and tools.
+func (a *Agent) applyConfig(cfg Config) {
+	a.model = cfg.Model
+	a.vision = cfg.Vision
+	a.temperature = cfg.Temperature
+	a.maxTokens = cfg.MaxTokens
+	a.retry = cfg.RetryPolicy()
+	a.maxToolRounds = cfg.Loop.MaxToolRounds
+	a.maxRepeatedCalls = cfg.Loop.MaxRepeatedCalls
+	a.permissions = cfg.Permissions
+	a.reviewEdits = cfg.Permissions.ReviewEdits
+	a.planMode = cfg.PlanMode
+	a.maxToolResultChars = cfg.Output.MaxToolResultChars
+	a.sessionLogDir = cfg.Output.SessionLogDir
+	a.postWrite = cfg.PostWrite
+	a.hooks = cfg.Hooks
+}
+
+const defaultModel = "llama-3.3-70b-versatile" // Using Llama 3.3 70B on Groq
+
+const (
+	maxMalformedResponses = 2 // empty or choice-less responses retried before giving up
+	maxContinuations      = 3 // automatic continuations of replies cut off by MaxTokens
+)
 
 type Agent struct {
 	client         *openai.Client
 	getUserMessage func() (string, bool)
 	tools          []ToolDefinition
+	model          string
+	vision         *bool // whether the model accepts images; guessed from its name when nil
+	temperature    *float32
+	maxTokens      int
+	retry          RetryPolicy
+	stream         bool
+
+	maxToolRounds      int
+	maxRepeatedCalls   int
+	permissions        PermissionConfig
+	reviewEdits        bool
+	planMode           bool // only read-only tools until the plan is approved
+	maxToolResultChars int
+	postWrite          []PostWriteHook // formatters and linters run on written files
+	hooks              HooksConfig
+	sessionID          string
+	sessionLogDir      string // where the session log is written; empty when disabled
+
+	sink     EventSink
+	approver Approver
+
+	files fileTracker // versions of files the model has seen
+	lsp   *LSPManager // nil when no language server is configured
+
+	mu            sync.Mutex // guards conversation, todos and pendingImages
+	conversation  []openai.ChatCompletionMessage
+	todos         []TodoItem
+	pendingImages []openai.ChatMessagePart // loaded by read_image, sent after the tool results
 }
 
-func (a *Agent) Run(ctx context.Context) error {
-	conversation := []openai.ChatCompletionMessage{}
+func (a *Agent) emit(e Event) {
+	a.sink.Emit(e)
+}
+
+// History returns a copy of the conversation so far.
+func (a *Agent) History() []openai.ChatCompletionMessage {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return slices.Clone(a.conversation)
+}
 
-	fmt.Println("Chat with Groq (use 'ctrl-c' to quit)")
+func (a *Agent) appendMessage(messages ...openai.ChatCompletionMessage) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.conversation = append(a.conversation, messages...)
+}
 
-	readUserInput := true
+// Run is the interactive loop: it reads user messages with getUserMessage
+// and runs a turn for each until input ends.
+func (a *Agent) Run(ctx context.Context) error {
+	interrupted := false
 	for {
-		if readUserInput {
-			fmt.Print("\u001b[94mYou\u001b[0m: ")
-			userInput, ok := a.getUserMessage()
-			if !ok {
-				break
+		a.emit(AwaitingInputEvent{})
+		userInput, ok := a.getUserMessage()
+		if !ok {
+			break
+		}
+
+		// An empty line after a failed turn resumes it instead of sending
+		// an empty message
+		if !(interrupted && strings.TrimSpace(userInput) == "") {
+			var ok bool
+			if userInput, ok = a.submitPrompt(ctx, userInput); !ok {
+				continue
 			}
+			message, attachments, err := a.userMessage(userInput)
+			if err != nil {
+				a.emit(ErrorEvent{Message: err.Error()})
+				continue
+			}
+			a.appendMessage(message)
+			a.emit(UserMessageEvent{Content: userInput, Attachments: attachments})
+		}
+		interrupted = false
 
-			userMessage := openai.ChatCompletionMessage{
-				Role:    openai.ChatMessageRoleUser,
-				Content: userInput,
+		err := a.runTurn(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
 			}
-			conversation = append(conversation, userMessage)
+			a.emit(ErrorEvent{Message: err.Error(), Resumable: true})
+			interrupted = true
 		}
+	}
+
+	return nil
+}
 
-		response, err := a.runInference(ctx, conversation)
+// runTurn runs inference and tool calls on the current conversation until
+// the model replies without calling tools, the user aborts a paused tool
+// loop, or inference fails.
+func (a *Agent) runTurn(ctx context.Context) error {
+	malformedResponses := 0
+	continuations := 0
+	guard := newToolLoopGuard(a.maxToolRounds, a.maxRepeatedCalls)
+	for {
+		response, err := a.runInference(ctx, a.History())
 		if err != nil {
-			fmt.Printf("\u001b[91mError\u001b[0m: %s\n", err.Error())
-			readUserInput = true
-			continue
+			return err
 		}
 
-		assistantMessage := response.Choices[0].Message
-		conversation = append(conversation, assistantMessage)
+		if len(response.Choices) == 0 {
+			malformedResponses++
+			if malformedResponses <= maxMalformedResponses {
+				continue
+			}
+			return fmt.Errorf("model returned no choices %d times in a row", malformedResponses)
+		}
+
+		choice := response.Choices[0]
+		assistantMessage := choice.Message
+
+		if assistantMessage.Content == "" && len(assistantMessage.ToolCalls) == 0 && choice.FinishReason != openai.FinishReasonLength {
+			malformedResponses++
+			if malformedResponses <= maxMalformedResponses {
+				a.appendMessage(openai.ChatCompletionMessage{
+					Role:    openai.ChatMessageRoleUser,
+					Content: "Your last response was empty. Please either answer or call one of the available tools.",
+				})
+				continue
+			}
+			return fmt.Errorf("model returned an empty response %d times in a row", malformedResponses)
+		}
+		malformedResponses = 0
+
+		a.appendMessage(assistantMessage)
 
 		// Handle tool calls
 		if len(assistantMessage.ToolCalls) > 0 {
+			continuations = 0
+
+			// Check the limits before running the calls, so that a repeated
+			// call doesn't run once more before the loop is stopped
+			if reason := guard.record(assistantMessage.ToolCalls); reason != "" {
+				decision, message := a.approver.ContinueToolLoop(reason)
+				switch decision {
+				case loopContinue:
+					guard.reset()
+				case loopRedirect:
+					guard.reset()
+					a.skipToolCalls(assistantMessage.ToolCalls, "Not run: the user paused the tool loop and sent new instructions.")
+					a.appendMessage(openai.ChatCompletionMessage{
+						Role:    openai.ChatMessageRoleUser,
+						Content: message,
+					})
+					continue
+				case loopAbort:
+					a.skipToolCalls(assistantMessage.ToolCalls, "Not run: the tool loop was stopped ("+reason+").")
+					return nil
+				}
+			}
+
 			for _, toolCall := range assistantMessage.ToolCalls {
-				result := a.executeTool(toolCall.ID, toolCall.Function.Name, json.RawMessage(toolCall.Function.Arguments))
-				toolMessage := openai.ChatCompletionMessage{
+				result := a.executeTool(ctx, toolCall.ID, toolCall.Function.Name, json.RawMessage(toolCall.Function.Arguments))
+				a.appendMessage(openai.ChatCompletionMessage{
 					Role:       openai.ChatMessageRoleTool,
 					Content:    result,
 					ToolCallID: toolCall.ID,
-				}
-				conversation = append(conversation, toolMessage)
+				})
 			}
-			readUserInput = false
-		} else {
-			fmt.Printf("\u001b[93mGroq\u001b[0m: %s\n", assistantMessage.Content)
-			readUserInput = true
+			a.flushPendingImages()
+			continue
+		}
+
+		a.emit(AssistantMessageEvent{Content: assistantMessage.Content, Streamed: a.stream})
+
+		// The reply hit MaxTokens; ask the model to pick up where it stopped
+		if choice.FinishReason == openai.FinishReasonLength && continuations < maxContinuations {
+			continuations++
+			a.appendMessage(openai.ChatCompletionMessage{
+				Role:    openai.ChatMessageRoleUser,
+				Content: "Your response was cut off because it reached the token limit. Continue exactly where you left off, without repeating anything.",
+			})
+			continue
+		}
+
+		// In plan mode the reply is the plan; once approved, the same turn
+		// goes on to carry it out
+		if a.planMode && a.reviewPlan(assistantMessage.Content) {
+			guard.reset()
+			continue
 		}
+		return nil
 	}
+}
 
-	return nil
+// skipToolCalls answers tool calls that were not run, since the API expects
+// a result for every call before the conversation goes on.
+func (a *Agent) skipToolCalls(toolCalls []openai.ToolCall, result string) {
+	for _, toolCall := range toolCalls {
+		a.appendMessage(openai.ChatCompletionMessage{
+			Role:       openai.ChatMessageRoleTool,
+			Content:    result,
+			ToolCallID: toolCall.ID,
+		})
+	}
 }
 
 func (a *Agent) runInference(ctx context.Context, conversation []openai.ChatCompletionMessage) (openai.ChatCompletionResponse, error) {
 	tools := []openai.Tool{}
-	for _, tool := range a.tools {
+	for _, tool := range a.availableTools() {
 		tools = append(tools, openai.Tool{
 			Type: openai.ToolTypeFunction,
 			Function: &openai.FunctionDefinition{
@@ -139,17 +423,142 @@ func (a *Agent) runInference(ctx context.Context, conversation []openai.ChatComp
 		})
 	}
 
-	response, err := a.client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
-		Model:      "llama-3.3-70b-versatile", // Using Llama 3.3 70B on Groq
-		Messages:   conversation,
+	request := openai.ChatCompletionRequest{
+		Model:      a.model,
+		Messages:   a.withPlanModePrompt(conversation),
 		Tools:      tools,
 		ToolChoice: "auto",
-		MaxTokens:  4000, // Increased token limit for longer responses
-	})
+		MaxTokens:  a.maxTokens,
+	}
+	if a.temperature != nil {
+		request.Temperature = *a.temperature
+	}
+	response, err := a.createChatCompletionWithRetry(ctx, request)
 	return response, err
 }
 
-func (a *Agent) executeTool(id, name string, input json.RawMessage) string {
+// streamChatCompletion sends request in streaming mode, passing content
+// deltas on as AssistantDeltaEvents, and assembles the chunks into the
+// same response shape CreateChatCompletion returns.
+func (a *Agent) streamChatCompletion(ctx context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	request.Stream = true
+	request.StreamOptions = &openai.StreamOptions{IncludeUsage: true}
+	stream, err := a.client.CreateChatCompletionStream(ctx, request)
+	if err != nil {
+		return openai.ChatCompletionResponse{}, err
+	}
+	defer stream.Close()
+
+	var response openai.ChatCompletionResponse
+	var content strings.Builder
+	var toolCalls []openai.ToolCall
+	var finishReason openai.FinishReason
+	received := false
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return openai.ChatCompletionResponse{}, err
+		}
+		response.ID = chunk.ID
+		response.Model = chunk.Model
+		if chunk.Usage != nil {
+			response.Usage = *chunk.Usage
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+		received = true
+
+		choice := chunk.Choices[0]
+		if choice.Delta.Content != "" {
+			content.WriteString(choice.Delta.Content)
+			a.emit(AssistantDeltaEvent{Content: choice.Delta.Content})
+		}
+		for _, delta := range choice.Delta.ToolCalls {
+			index := len(toolCalls)
+			if delta.Index != nil {
+				index = *delta.Index
+			}
+			for len(toolCalls) <= index {
+				toolCalls = append(toolCalls, openai.ToolCall{Type: openai.ToolTypeFunction})
+			}
+			if delta.ID != "" {
+				toolCalls[index].ID = delta.ID
+			}
+			toolCalls[index].Function.Name += delta.Function.Name
+			toolCalls[index].Function.Arguments += delta.Function.Arguments
+		}
+		if choice.FinishReason != "" {
+			finishReason = choice.FinishReason
+		}
+	}
+
+	if received {
+		response.Choices = []openai.ChatCompletionChoice{{
+			Message: openai.ChatCompletionMessage{
+				Role:      openai.ChatMessageRoleAssistant,
+				Content:   content.String(),
+				ToolCalls: toolCalls,
+			},
+			FinishReason: finishReason,
+		}}
+	}
+	return response, nil
+}
+
+func (a *Agent) executeTool(ctx context.Context, id, name string, input json.RawMessage) string {
+	// Models often send an empty string for tools without required arguments
+	if len(strings.TrimSpace(string(input))) == 0 {
+		input = json.RawMessage("{}")
+	}
+
+	// Hooks may block the call or rewrite its input before it is shown
+	pre := hookResult{}
+	if len(a.hooks.PreToolUse) > 0 {
+		pre = a.runHooks(ctx, HookPreToolUse, hookInput{ToolName: name, ToolInput: input})
+		if pre.toolInput != nil {
+			input = pre.toolInput
+		}
+	}
+
+	// Keep the event serializable even when the model sent broken JSON
+	shownInput := input
+	if !json.Valid(input) {
+		shownInput, _ = json.Marshal(string(input))
+	}
+	a.emit(ToolCallStartedEvent{ID: id, Name: name, Input: shownInput})
+
+	var result string
+	var isError bool
+	if pre.blocked {
+		result, isError = hookBlockedMessage(name, pre.reason), true
+	} else {
+		result, isError = a.callTool(ctx, id, name, input)
+		if len(a.hooks.PostToolUse) > 0 {
+			post := a.runHooks(ctx, HookPostToolUse, hookInput{ToolName: name, ToolInput: input, ToolResult: &result, IsError: isError})
+			if post.blocked && post.reason != "" {
+				post.context = append(post.context, post.reason)
+			}
+			for _, context := range post.context {
+				result += "\n\n" + context
+			}
+		}
+	}
+	for _, context := range pre.context {
+		result += "\n\n" + context
+	}
+	result = truncateToolResult(result, a.maxToolResultChars)
+
+	a.emit(ToolCallFinishedEvent{ID: id, Name: name, Result: result, IsError: isError})
+	return result
+}
+
+// callTool looks up, validates and runs a tool call. Every failure is
+// returned as text for the model, with isError set.
+func (a *Agent) callTool(ctx context.Context, id, name string, input json.RawMessage) (result string, isError bool) {
 	var toolDef ToolDefinition
 	var found bool
 	for _, tool := range a.tools {
@@ -159,16 +568,159 @@ func (a *Agent) executeTool(id, name string, input json.RawMessage) string {
 			break
 		}
 	}
+	if found && a.planMode && !toolDef.ReadOnly {
+		return planModeDenial(name), true
+	}
 	if !found {
-		return "tool not found"
+		return fmt.Sprintf("tool %q not found. Available tools: %s", name, strings.Join(a.toolNames(), ", ")), true
+	}
+
+	if err := validateToolInput(toolDef, input); err != nil {
+		return toolInputRepairHint(toolDef, err), true
+	}
+
+	// Tools report their own input errors, so a preview failing on bad input
+	// just means there is no diff to show. Any other failure would skip the
+	// checks below, so the call is refused.
+	var changes []FileChange
+	if toolDef.Preview != nil {
+		var err error
+		changes, err = toolDef.Preview(input)
+		if err != nil && !errors.As(err, new(invalidInputError)) {
+			return fmt.Sprintf("failed to prepare the change: %s", err), true
+		}
+	}
+
+	// Refuse changes based on a missing or outdated read of a file
+	if !toolDef.editsUnreadFiles {
+		for _, change := range changes {
+			if err := a.files.check(change.Path); err != nil {
+				return err.Error(), true
+			}
+		}
+	}
+
+	if denied := a.checkPermission(id, name, input, changes); denied != "" {
+		return denied, true
 	}
 
-	fmt.Printf("\u001b[92mtool\u001b[0m: %s(%s)\n", name, input)
-	response, err := toolDef.Function(input)
+	var response string
+	var err error
+	if toolDef.agentFunction != nil {
+		response, err = toolDef.agentFunction(ctx, a, input)
+	} else {
+		response, err = toolDef.Function(input)
+	}
 	if err != nil {
-		return err.Error()
+		a.logFileChanges(id, name, "failed", changes)
+		return err.Error(), true
+	}
+	a.logFileChanges(id, name, "applied", changes)
+
+	paths := make([]string, len(changes))
+	for i, change := range changes {
+		paths[i] = change.Path
+	}
+	notes := runPostWriteHooks(a.postWrite, paths)
+
+	// The model now knows the current contents of files it read or wrote
+	for _, change := range changes {
+		a.files.record(change.Path)
+		if change.OldPath != "" {
+			a.files.record(change.OldPath)
+		}
+		if filepath.Ext(change.Path) == ".go" || filepath.Ext(change.OldPath) == ".go" {
+			invalidateGoExports()
+		}
 	}
-	return response
+	if name == TerminalRunDefinition.Name {
+		invalidateGoExports() // commands may have changed Go files too
+	}
+	if name == ReadFileDefinition.Name {
+		var readInput ReadFileInput
+		if json.Unmarshal(input, &readInput) == nil {
+			a.files.record(readInput.Path)
+		}
+	}
+
+	// Report what the change broke while the model still has it in mind
+	if notes != "" {
+		response += "\n\n" + notes
+	}
+	if diagnostics := a.lsp.diagnosticsAfterWrite(paths); diagnostics != "" {
+		response += "\n\n" + diagnostics
+	}
+	return response, false
+}
+
+// truncateToolResult shortens a tool result to at most limit bytes, keeping
+// the start and end since errors usually show up at the end of command
+// output.
+func truncateToolResult(result string, limit int) string {
+	if limit <= 0 || len(result) <= limit {
+		return result
+	}
+	// Cut at rune boundaries so that no character is split in half
+	head := limit * 3 / 4
+	for head > 0 && !utf8.RuneStart(result[head]) {
+		head--
+	}
+	tail := len(result) - (limit - limit*3/4)
+	for tail < len(result) && !utf8.RuneStart(result[tail]) {
+		tail++
+	}
+	omitted := utf8.RuneCountInString(result[head:tail])
+	return fmt.Sprintf("%s\n\n[... %d characters truncated ...]\n\n%s", result[:head], omitted, result[tail:])
+}
+
+func (a *Agent) toolNames() []string {
+	tools := a.availableTools()
+	names := make([]string, 0, len(tools))
+	for _, tool := range tools {
+		names = append(names, tool.Name)
+	}
+	return names
+}
+
+// validateToolInput checks that input is a JSON object containing every
+// argument the tool's schema marks as required.
+func validateToolInput(tool ToolDefinition, input json.RawMessage) error {
+	var args map[string]any
+	if err := json.Unmarshal(input, &args); err != nil {
+		return fmt.Errorf("arguments are not a valid JSON object: %w", err)
+	}
+
+	schema, ok := tool.InputSchema.(map[string]any)
+	if !ok {
+		return nil
+	}
+	var required []string
+	switch r := schema["required"].(type) {
+	case []string:
+		required = r
+	case []any: // schemas decoded from JSON, e.g. MCP tools
+		for _, field := range r {
+			if s, ok := field.(string); ok {
+				required = append(required, s)
+			}
+		}
+	}
+	var missing []string
+	for _, field := range required {
+		if _, ok := args[field]; !ok {
+			missing = append(missing, field)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required argument(s): %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func toolInputRepairHint(tool ToolDefinition, err error) string {
+	schema, _ := json.Marshal(tool.InputSchema)
+	return fmt.Sprintf("invalid arguments for tool %s: %s. Call %s again with a single JSON object matching this schema: %s",
+		tool.Name, err, tool.Name, schema)
 }
 
 func GenerateSchema[T any]() any {
@@ -198,6 +750,7 @@ var ReadFileDefinition = ToolDefinition{
 	Description: "Read the contents of a given relative file path. Use this when you want to see what's inside a file. Do not use this with directory names.",
 	InputSchema: ReadFileInputSchema,
 	Function:    ReadFile,
+	ReadOnly:    true,
 }
 
 type ReadFileInput struct {
@@ -219,7 +772,7 @@ func ReadFile(input json.RawMessage) (string, error) {
 	readFileInput := ReadFileInput{}
 	err := json.Unmarshal(input, &readFileInput)
 	if err != nil {
-		panic(err)
+		return "", err
 	}
 
 	content, err := os.ReadFile(readFileInput.Path)
@@ -234,6 +787,7 @@ var ListFilesDefinition = ToolDefinition{
 	Description: "List files and directories at a given path. If no path is provided, lists files in the current directory.",
 	InputSchema: ListFilesInputSchema,
 	Function:    ListFiles,
+	ReadOnly:    true,
 }
 
 type ListFilesInput struct {
@@ -255,7 +809,7 @@ func ListFiles(input json.RawMessage) (string, error) {
 	listFilesInput := ListFilesInput{}
 	err := json.Unmarshal(input, &listFilesInput)
 	if err != nil {
-		panic(err)
+		return "", err
 	}
 
 	dir := "."
@@ -296,6 +850,85 @@ func ListFiles(input json.RawMessage) (string, error) {
 	return string(result), nil
 }
 
+var SearchFilesDefinition = ToolDefinition{
+	Name:        "search_files",
+	Description: "Search file contents for a regular expression. Returns matching lines as path:line: text. Use this to find where something is defined or used.",
+	InputSchema: GenerateSchema[SearchFilesInput](),
+	Function:    SearchFiles,
+	ReadOnly:    true,
+}
+
+type SearchFilesInput struct {
+	Pattern string `json:"pattern" jsonschema:"required" jsonschema_description:"Regular expression (Go RE2 syntax) to search for"`
+	Path    string `json:"path,omitempty" jsonschema_description:"Optional relative directory or file to search. Defaults to the current directory."`
+	Glob    string `json:"glob,omitempty" jsonschema_description:"Optional file name pattern such as *.go to restrict the search"`
+}
+
+const maxSearchMatches = 200
+
+func SearchFiles(input json.RawMessage) (string, error) {
+	searchInput := SearchFilesInput{}
+	if err := json.Unmarshal(input, &searchInput); err != nil {
+		return "", err
+	}
+
+	re, err := regexp.Compile(searchInput.Pattern)
+	if err != nil {
+		return "", fmt.Errorf("invalid pattern: %w", err)
+	}
+	root := "."
+	if searchInput.Path != "" {
+		root = searchInput.Path
+	}
+
+	var matches []string
+	truncated := false
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != root && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if searchInput.Glob != "" {
+			if ok, _ := filepath.Match(searchInput.Glob, d.Name()); !ok {
+				return nil
+			}
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil || bytes.IndexByte(content, 0) >= 0 {
+			return nil // unreadable or binary
+		}
+		for i, line := range strings.Split(string(content), "\n") {
+			if !re.MatchString(line) {
+				continue
+			}
+			if len(matches) == maxSearchMatches {
+				truncated = true
+				return filepath.SkipAll
+			}
+			matches = append(matches, fmt.Sprintf("%s:%d: %s", path, i+1, strings.TrimRight(line, "\r")))
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if len(matches) == 0 {
+		return "No matches found", nil
+	}
+	result := strings.Join(matches, "\n")
+	if truncated {
+		result += fmt.Sprintf("\n[stopped after %d matches; narrow the pattern, path or glob]", maxSearchMatches)
+	}
+	return result, nil
+}
+
 var EditFileDefinition = ToolDefinition{
 	Name: "edit_file",
 	Description: `Make edits to a text file.
@@ -306,6 +939,7 @@ If the file specified with path doesn't exist, it will be created.
 `,
 	InputSchema: EditFileInputSchema,
 	Function:    EditFile,
+	Preview:     previewOne(PreviewEditFile),
 }
 
 type EditFileInput struct {
@@ -352,14 +986,12 @@ func EditFile(input json.RawMessage) (string, error) {
 		return "", err
 	}
 
-	oldContent := string(content)
-	newContent := strings.Replace(oldContent, editFileInput.OldStr, editFileInput.NewStr, -1)
-
-	if oldContent == newContent && editFileInput.OldStr != "" {
-		return "", fmt.Errorf("old_str not found in file")
+	newContent, err := applyEdit(string(content), editFileInput.OldStr, editFileInput.NewStr)
+	if err != nil {
+		return "", err
 	}
 
-	err = os.WriteFile(editFileInput.Path, []byte(newContent), 0644)
+	err = writeFileAtomic(editFileInput.Path, []byte(newContent), 0644)
 	if err != nil {
 		return "", err
 	}
@@ -367,6 +999,29 @@ func EditFile(input json.RawMessage) (string, error) {
 	return "OK", nil
 }
 
+func PreviewEditFile(input json.RawMessage) (*FileChange, error) {
+	editFileInput := EditFileInput{}
+	if err := json.Unmarshal(input, &editFileInput); err != nil {
+		return nil, invalidInput(err)
+	}
+
+	content, err := os.ReadFile(editFileInput.Path)
+	if os.IsNotExist(err) {
+		if editFileInput.OldStr == "" {
+			return newFileChange(editFileInput.Path, editFileInput.NewStr)
+		}
+		return nil, invalidInput(err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	newContent, err := applyEdit(string(content), editFileInput.OldStr, editFileInput.NewStr)
+	if err != nil {
+		return nil, invalidInput(err)
+	}
+	return newFileChange(editFileInput.Path, newContent)
+}
+
 func createNewFile(filePath, content string) (string, error) {
 	dir := path.Dir(filePath)
 	if dir != "." {
@@ -376,7 +1031,7 @@ func createNewFile(filePath, content string) (string, error) {
 		}
 	}
 
-	err := os.WriteFile(filePath, []byte(content), 0644)
+	err := writeFileAtomic(filePath, []byte(content), 0644)
 	if err != nil {
 		return "", fmt.Errorf("failed to create file: %w", err)
 	}
@@ -389,6 +1044,7 @@ var CreateFileDefinition = ToolDefinition{
 	Description: "Create a new file with specified content. If the file already exists, it will be overwritten.",
 	InputSchema: CreateFileInputSchema,
 	Function:    CreateFile,
+	Preview:     previewOne(PreviewCreateFile),
 }
 
 type CreateFileInput struct {
@@ -431,7 +1087,7 @@ func CreateFile(input json.RawMessage) (string, error) {
 		}
 	}
 
-	err = os.WriteFile(createFileInput.Path, []byte(createFileInput.Content), 0644)
+	err = writeFileAtomic(createFileInput.Path, []byte(createFileInput.Content), 0644)
 	if err != nil {
 		return "", fmt.Errorf("failed to create file: %w", err)
 	}
@@ -439,11 +1095,20 @@ func CreateFile(input json.RawMessage) (string, error) {
 	return fmt.Sprintf("Successfully created file %s", createFileInput.Path), nil
 }
 
+func PreviewCreateFile(input json.RawMessage) (*FileChange, error) {
+	createFileInput := CreateFileInput{}
+	if err := json.Unmarshal(input, &createFileInput); err != nil {
+		return nil, invalidInput(err)
+	}
+	return newFileChange(createFileInput.Path, createFileInput.Content)
+}
+
 var DeleteFileDefinition = ToolDefinition{
 	Name:        "delete_file",
 	Description: "Delete an existing file. Use with caution as this action cannot be undone.",
 	InputSchema: DeleteFileInputSchema,
 	Function:    DeleteFile,
+	Preview:     previewOne(PreviewDeleteFile),
 }
 
 type DeleteFileInput struct {
@@ -485,11 +1150,28 @@ func DeleteFile(input json.RawMessage) (string, error) {
 	return fmt.Sprintf("Successfully deleted file %s", deleteFileInput.Path), nil
 }
 
+func PreviewDeleteFile(input json.RawMessage) (*FileChange, error) {
+	deleteFileInput := DeleteFileInput{}
+	if err := json.Unmarshal(input, &deleteFileInput); err != nil {
+		return nil, invalidInput(err)
+	}
+	if deleteFileInput.Path == "" {
+		return nil, invalidInput(fmt.Errorf("path cannot be empty"))
+	}
+
+	change, err := deletedFileChange(deleteFileInput.Path)
+	if os.IsNotExist(err) {
+		return nil, invalidInput(err)
+	}
+	return change, err
+}
+
 var RenameFileDefinition = ToolDefinition{
 	Name:        "rename_file",
 	Description: "Rename or move a file from one location to another.",
 	InputSchema: RenameFileInputSchema,
 	Function:    RenameFile,
+	Preview:     previewOne(PreviewRenameFile),
 }
 
 type RenameFileInput struct {
@@ -545,6 +1227,34 @@ func RenameFile(input json.RawMessage) (string, error) {
 	return fmt.Sprintf("Successfully renamed %s to %s", renameFileInput.OldPath, renameFileInput.NewPath), nil
 }
 
+func PreviewRenameFile(input json.RawMessage) (*FileChange, error) {
+	renameFileInput := RenameFileInput{}
+	if err := json.Unmarshal(input, &renameFileInput); err != nil {
+		return nil, invalidInput(err)
+	}
+
+	content, err := os.ReadFile(renameFileInput.OldPath)
+	if os.IsNotExist(err) {
+		return nil, invalidInput(err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	change := &FileChange{Path: renameFileInput.NewPath, OldPath: renameFileInput.OldPath, Created: true}
+
+	// Only an overwritten destination has contents worth diffing
+	if _, err := os.Stat(renameFileInput.NewPath); err == nil {
+		overwrite, err := newFileChange(renameFileInput.NewPath, string(content))
+		if err != nil {
+			return nil, err
+		}
+		change.Created = false
+		change.Diff = overwrite.Diff
+	}
+	change.Diff = fmt.Sprintf("rename from %s\nrename to %s\n", renameFileInput.OldPath, renameFileInput.NewPath) + change.Diff
+	return change, nil
+}
+
 var CreateFolderDefinition = ToolDefinition{
 	Name:        "create_folder",
 	Description: "Create a new directory/folder. Creates parent directories if they don't exist.",
@@ -692,6 +1402,10 @@ func RenameFolder(input json.RawMessage) (string, error) {
 	return fmt.Sprintf("Successfully renamed directory %s to %s", renameFolderInput.OldPath, renameFolderInput.NewPath), nil
 }
 
+// terminalRunTimeout is used when the model doesn't pass a timeout; it is
+// set from the timeouts.terminal_run config value.
+var terminalRunTimeout = 30 * time.Second
+
 var TerminalRunDefinition = ToolDefinition{
 	Name:        "terminal_run",
 	Description: "Execute a terminal/command line command and return its output. Use with caution as this can execute any system command.",
@@ -731,22 +1445,16 @@ func TerminalRun(input json.RawMessage) (string, error) {
 	}
 
 	// Set default timeout
-	timeout := 30
+	timeout := terminalRunTimeout
 	if terminalRunInput.Timeout > 0 {
-		timeout = terminalRunInput.Timeout
+		timeout = time.Duration(terminalRunInput.Timeout) * time.Second
 	}
 
 	// Create context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
-	// Execute command based on OS
-	var cmd *exec.Cmd
-	if strings.Contains(strings.ToLower(os.Getenv("OS")), "windows") {
-		cmd = exec.CommandContext(ctx, "powershell", "-Command", terminalRunInput.Command)
-	} else {
-		cmd = exec.CommandContext(ctx, "sh", "-c", terminalRunInput.Command)
-	}
+	cmd := shellCommand(ctx, terminalRunInput.Command)
 
 	// Capture both stdout and stderr
 	output, err := cmd.CombinedOutput()
@@ -762,11 +1470,33 @@ func TerminalRun(input json.RawMessage) (string, error) {
 	return result, nil
 }
 
+// onWindows reports whether commands run under PowerShell instead of sh.
+func onWindows() bool {
+	return strings.Contains(strings.ToLower(os.Getenv("OS")), "windows")
+}
+
+// shellCommand runs command with the platform's shell.
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if onWindows() {
+		return exec.CommandContext(ctx, "powershell", "-Command", command)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
+
+// shellQuote quotes s as a single word for the shell used by shellCommand.
+func shellQuote(s string) string {
+	if onWindows() {
+		return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
 var CreateWebsiteDefinition = ToolDefinition{
 	Name:        "create_website",
 	Description: "Create a complete website with HTML, CSS, and JavaScript files. This tool creates separate files for better organization and handles large content.",
 	InputSchema: CreateWebsiteInputSchema,
 	Function:    CreateWebsite,
+	Preview:     PreviewCreateWebsite,
 }
 
 type CreateWebsiteInput struct {
@@ -819,7 +1549,7 @@ func CreateWebsite(input json.RawMessage) (string, error) {
 	// Create HTML file
 	htmlContent := generateHTML(websiteInput.ProjectName, websiteInput.Description, websiteInput.Style)
 	htmlPath := filepath.Join(websiteInput.FolderPath, "index.html")
-	err = os.WriteFile(htmlPath, []byte(htmlContent), 0644)
+	err = writeFileAtomic(htmlPath, []byte(htmlContent), 0644)
 	if err != nil {
 		return "", fmt.Errorf("failed to create HTML file: %w", err)
 	}
@@ -827,7 +1557,7 @@ func CreateWebsite(input json.RawMessage) (string, error) {
 	// Create CSS file
 	cssContent := generateCSS(websiteInput.Style)
 	cssPath := filepath.Join(websiteInput.FolderPath, "style.css")
-	err = os.WriteFile(cssPath, []byte(cssContent), 0644)
+	err = writeFileAtomic(cssPath, []byte(cssContent), 0644)
 	if err != nil {
 		return "", fmt.Errorf("failed to create CSS file: %w", err)
 	}
@@ -835,7 +1565,7 @@ func CreateWebsite(input json.RawMessage) (string, error) {
 	// Create JS file
 	jsContent := generateJS(websiteInput.ProjectName)
 	jsPath := filepath.Join(websiteInput.FolderPath, "script.js")
-	err = os.WriteFile(jsPath, []byte(jsContent), 0644)
+	err = writeFileAtomic(jsPath, []byte(jsContent), 0644)
 	if err != nil {
 		return "", fmt.Errorf("failed to create JS file: %w", err)
 	}
@@ -843,6 +1573,31 @@ func CreateWebsite(input json.RawMessage) (string, error) {
 	return fmt.Sprintf("Successfully created website '%s' in %s with files: index.html, style.css, script.js", websiteInput.ProjectName, websiteInput.FolderPath), nil
 }
 
+func PreviewCreateWebsite(input json.RawMessage) ([]FileChange, error) {
+	websiteInput := CreateWebsiteInput{}
+	if err := json.Unmarshal(input, &websiteInput); err != nil {
+		return nil, invalidInput(err)
+	}
+	if websiteInput.FolderPath == "" || websiteInput.ProjectName == "" || websiteInput.Description == "" {
+		return nil, invalidInput(fmt.Errorf("folder_path, project_name, and description are required"))
+	}
+
+	files := []struct{ name, content string }{
+		{"index.html", generateHTML(websiteInput.ProjectName, websiteInput.Description, websiteInput.Style)},
+		{"style.css", generateCSS(websiteInput.Style)},
+		{"script.js", generateJS(websiteInput.ProjectName)},
+	}
+	changes := make([]FileChange, 0, len(files))
+	for _, file := range files {
+		change, err := newFileChange(filepath.Join(websiteInput.FolderPath, file.name), file.content)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, *change)
+	}
+	return changes, nil
+}
+
 func generateHTML(projectName, description, style string) string {
 	return fmt.Sprintf(`<!DOCTYPE html>
 <html lang="en">
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	styleBold      = "\u001b[1m"
+	styleItalic    = "\u001b[3m"
+	styleUnderline = "\u001b[4m"
+	styleStrike    = "\u001b[9m"
+	styleDim       = "\u001b[2m"
+	styleHeading   = "\u001b[1;96m" // bold bright cyan
+	styleCode      = "\u001b[36m"   // cyan
+)
+
+// renderMarkdown formats Markdown for an ANSI terminal that is width columns
+// wide. It understands the subset models actually produce: headings,
+// emphasis, inline and fenced code, lists, block quotes, tables and rules.
+// Anything else is passed through as wrapped text.
+func renderMarkdown(text string, width int) string {
+	if width < 20 {
+		width = 20
+	}
+
+	var out []string
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	var paragraph []string
+
+	flush := func() {
+		if len(paragraph) > 0 {
+			out = append(out, wrapText(renderInline(strings.Join(paragraph, " ")), width, "", "")...)
+			paragraph = nil
+		}
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case trimmed == "":
+			flush()
+			out = append(out, "")
+
+		case strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~"):
+			flush()
+			fence := trimmed[:3]
+			lang := strings.TrimSpace(strings.TrimLeft(trimmed, fence[:1]))
+			var code []string
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), fence); i++ {
+				code = append(code, lines[i])
+			}
+			out = append(out, renderCodeBlock(strings.Join(code, "\n"), lang)...)
+
+		case headingPattern.MatchString(trimmed):
+			flush()
+			m := headingPattern.FindStringSubmatch(trimmed)
+			heading := renderInline(strings.TrimRight(m[2], " #"))
+			for _, l := range wrapText(heading, width, "", "") {
+				out = append(out, styleHeading+l+styleReset)
+			}
+
+		case rulePattern.MatchString(trimmed):
+			flush()
+			out = append(out, styleDim+strings.Repeat("─", width)+styleReset)
+
+		case strings.HasPrefix(trimmed, ">"):
+			flush()
+			var quote []string
+			for ; i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), ">"); i++ {
+				q := strings.TrimPrefix(strings.TrimSpace(lines[i]), ">")
+				quote = append(quote, strings.TrimSpace(q))
+			}
+			i--
+			prefix := styleDim + "│ " + styleReset
+			out = append(out, wrapText(styleItalic+renderInline(strings.Join(quote, " "))+styleReset, width, prefix, prefix)...)
+
+		case listPattern.MatchString(line):
+			flush()
+			m := listPattern.FindStringSubmatch(line)
+			indent := strings.Repeat(" ", len(expandTabs(m[1])))
+			marker := m[2]
+			if marker == "-" || marker == "*" || marker == "+" {
+				marker = "•"
+			}
+			item := m[3]
+			// Lazy continuation lines belong to the item.
+			for i+1 < len(lines) && isContinuation(lines[i+1]) {
+				i++
+				item += " " + strings.TrimSpace(lines[i])
+			}
+			first := indent + marker + " "
+			rest := indent + strings.Repeat(" ", utf8.RuneCountInString(marker)+1)
+			out = append(out, wrapText(renderInline(item), width, first, rest)...)
+
+		case strings.HasPrefix(trimmed, "|") && i+1 < len(lines) && tableSeparator.MatchString(strings.TrimSpace(lines[i+1])):
+			flush()
+			var rows [][]string
+			for ; i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), "|"); i++ {
+				row := strings.TrimSpace(lines[i])
+				if tableSeparator.MatchString(row) {
+					continue
+				}
+				rows = append(rows, splitTableRow(row))
+			}
+			i--
+			out = append(out, renderTable(rows, width)...)
+
+		default:
+			paragraph = append(paragraph, trimmed)
+		}
+	}
+	flush()
+
+	// Collapse runs of blank lines and trim them at the ends.
+	var result []string
+	for _, l := range out {
+		if l == "" && (len(result) == 0 || result[len(result)-1] == "") {
+			continue
+		}
+		result = append(result, l)
+	}
+	for len(result) > 0 && result[len(result)-1] == "" {
+		result = result[:len(result)-1]
+	}
+	return strings.Join(result, "\n")
+}
+
+var (
+	headingPattern = regexp.MustCompile(`^(#{1,6})\s+(.*)$`)
+	rulePattern    = regexp.MustCompile(`^([-*_])(\s*([-*_]))+$`)
+	listPattern    = regexp.MustCompile(`^(\s*)([-*+]|\d+[.)])\s+(.*)$`)
+	tableSeparator = regexp.MustCompile(`^\|?(\s*:?-+:?\s*\|)+\s*(:?-+:?\s*)?$`)
+)
+
+// isContinuation reports whether line continues the preceding list item
+// rather than starting a new block.
+func isContinuation(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" || !strings.HasPrefix(line, " ") {
+		return false
+	}
+	return !listPattern.MatchString(line) && !strings.HasPrefix(trimmed, "```") &&
+		!strings.HasPrefix(trimmed, ">") && !strings.HasPrefix(trimmed, "|")
+}
+
+func renderCodeBlock(code, lang string) []string {
+	var out []string
+	if lang != "" {
+		out = append(out, styleDim+"  "+lang+styleReset)
+	}
+	for _, l := range strings.Split(highlightCode(expandTabs(code), lang), "\n") {
+		out = append(out, "  "+l)
+	}
+	return out
+}
+
+func splitTableRow(row string) []string {
+	row = strings.TrimSuffix(strings.TrimPrefix(row, "|"), "|")
+	var cells []string
+	for _, cell := range strings.Split(row, "|") {
+		cells = append(cells, renderInline(strings.TrimSpace(cell)))
+	}
+	return cells
+}
+
+// renderTable aligns the rows into columns. Tables wider than the terminal
+// are left unaligned rather than wrapped cell by cell.
+func renderTable(rows [][]string, width int) []string {
+	var widths []int
+	for _, row := range rows {
+		for c, cell := range row {
+			if c >= len(widths) {
+				widths = append(widths, 0)
+			}
+			widths[c] = max(widths[c], visibleWidth(cell))
+		}
+	}
+
+	total := 1
+	for _, w := range widths {
+		total += w + 3
+	}
+
+	sep := styleDim + "│" + styleReset
+	var out []string
+	for r, row := range rows {
+		var b strings.Builder
+		b.WriteString(sep)
+		for c := range widths {
+			cell := ""
+			if c < len(row) {
+				cell = row[c]
+			}
+			if r == 0 {
+				cell = styleBold + cell + styleReset
+			}
+			b.WriteString(" " + cell)
+			if total <= width {
+				b.WriteString(strings.Repeat(" ", widths[c]-visibleWidth(cell)))
+			}
+			b.WriteString(" " + sep)
+		}
+		out = append(out, b.String())
+
+		if r == 0 && total <= width {
+			var rule []string
+			for _, w := range widths {
+				rule = append(rule, strings.Repeat("─", w+2))
+			}
+			out = append(out, styleDim+"├"+strings.Join(rule, "┼")+"┤"+styleReset)
+		}
+	}
+	return out
+}
+
+var inlinePattern = regexp.MustCompile("`[^`]+`|\\*\\*[^*]+\\*\\*|__[^_]+__|~~[^~]+~~|\\*[^*\\s][^*]*\\*|\\b_[^_\\s][^_]*_\\b|\\[[^\\]]+\\]\\([^)\\s]+\\)")
+
+// renderInline styles code spans, emphasis and links.
+func renderInline(s string) string {
+	return inlinePattern.ReplaceAllStringFunc(s, func(m string) string {
+		switch {
+		case strings.HasPrefix(m, "`"):
+			return styleCode + m[1:len(m)-1] + styleReset
+		case strings.HasPrefix(m, "**"), strings.HasPrefix(m, "__"):
+			return styleBold + renderInline(m[2:len(m)-2]) + styleReset
+		case strings.HasPrefix(m, "~~"):
+			return styleStrike + m[2:len(m)-2] + styleReset
+		case strings.HasPrefix(m, "["):
+			text, url, _ := strings.Cut(m[1:len(m)-1], "](")
+			if text == url {
+				return styleUnderline + url + styleReset
+			}
+			return styleUnderline + text + styleReset + " (" + url + ")"
+		default:
+			return styleItalic + m[1:len(m)-1] + styleReset
+		}
+	})
+}
+
+var ansiPattern = regexp.MustCompile("\u001b\\[[0-9;]*m")
+
+// visibleWidth is the number of columns s occupies, ignoring ANSI escapes.
+func visibleWidth(s string) int {
+	return utf8.RuneCountInString(ansiPattern.ReplaceAllString(s, ""))
+}
+
+// wrapText breaks s into lines no wider than width, starting the first line
+// with first and the rest with rest. Words longer than a line are kept whole.
+func wrapText(s string, width int, first, rest string) []string {
+	var lines []string
+	line := first
+	lineWidth := visibleWidth(first)
+	empty := true
+
+	for _, word := range strings.Fields(s) {
+		w := visibleWidth(word)
+		if !empty && lineWidth+1+w > width {
+			lines = append(lines, line)
+			line, lineWidth, empty = rest, visibleWidth(rest), true
+		}
+		if !empty {
+			line += " "
+			lineWidth++
+		}
+		line += word
+		lineWidth += w
+		empty = false
+	}
+	return append(lines, line)
+}
+
+func expandTabs(s string) string {
+	return strings.ReplaceAll(s, "\t", "    ")
+}
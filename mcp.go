@@ -0,0 +1,455 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// mcpProtocolVersion is the Model Context Protocol revision this client and
+// server implement.
+const mcpProtocolVersion = "2025-03-26"
+
+// MCPServerConfig declares an external MCP server under mcp_servers in the
+// config file. Servers with a command are launched over stdio; servers with
+// a url are reached over streamable HTTP, or the older SSE transport when
+// transport is "sse". Env and header values may reference ${VARS}.
+type MCPServerConfig struct {
+	Command   string            `yaml:"command,omitempty"`
+	Args      []string          `yaml:"args,omitempty"`
+	Env       map[string]string `yaml:"env,omitempty"`
+	URL       string            `yaml:"url,omitempty"`
+	Transport string            `yaml:"transport,omitempty"`
+	Headers   map[string]string `yaml:"headers,omitempty"`
+	Disabled  bool              `yaml:"disabled,omitempty"`
+}
+
+type mcpTool struct {
+	Name        string              `json:"name"`
+	Description string              `json:"description,omitempty"`
+	InputSchema map[string]any      `json:"inputSchema"`
+	Annotations *mcpToolAnnotations `json:"annotations,omitempty"`
+}
+
+type mcpToolAnnotations struct {
+	ReadOnlyHint bool `json:"readOnlyHint,omitempty"`
+}
+
+type mcpContent struct {
+	Type     string       `json:"type"`
+	Text     string       `json:"text,omitempty"`
+	MimeType string       `json:"mimeType,omitempty"`
+	Data     string       `json:"data,omitempty"`
+	Resource *mcpResource `json:"resource,omitempty"`
+}
+
+type mcpCallToolResult struct {
+	Content []mcpContent `json:"content"`
+	IsError bool         `json:"isError,omitempty"`
+}
+
+type mcpResource struct {
+	URI         string `json:"uri"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+	MimeType    string `json:"mimeType,omitempty"`
+	Text        string `json:"text,omitempty"`
+	Blob        string `json:"blob,omitempty"`
+}
+
+type mcpImplementation struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type mcpInitializeResult struct {
+	ProtocolVersion string            `json:"protocolVersion"`
+	Capabilities    map[string]any    `json:"capabilities"`
+	ServerInfo      mcpImplementation `json:"serverInfo"`
+	Instructions    string            `json:"instructions,omitempty"`
+}
+
+// MCPClient is a connection to one MCP server.
+type MCPClient struct {
+	*jsonrpcClient
+	name      string
+	transport mcpTransport
+	timeout   time.Duration
+
+	serverInfo mcpInitializeResult
+}
+
+// ConnectMCPServer starts the transport for cfg and performs the MCP
+// initialize handshake.
+func ConnectMCPServer(ctx context.Context, name string, cfg MCPServerConfig, timeout time.Duration) (*MCPClient, error) {
+	transport, err := newMCPTransport(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &MCPClient{
+		name:      name,
+		transport: transport,
+		timeout:   timeout,
+	}
+	c.jsonrpcClient = &jsonrpcClient{
+		peer:   "mcp server " + name,
+		write:  transport.send,
+		serve:  c.serve,
+		cancel: c.cancelRequest,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if err := transport.start(ctx, c.handle); err != nil {
+		return nil, err
+	}
+
+	params := map[string]any{
+		"protocolVersion": mcpProtocolVersion,
+		"capabilities":    map[string]any{},
+		"clientInfo":      mcpImplementation{Name: "agent", Version: "1.0.0"},
+	}
+	if err := c.call(ctx, "initialize", params, &c.serverInfo); err != nil {
+		transport.close()
+		return nil, fmt.Errorf("initialize: %w", err)
+	}
+	if err := c.notify(ctx, "notifications/initialized", nil); err != nil {
+		transport.close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *MCPClient) Close() error {
+	return c.transport.close()
+}
+
+// serve answers the server's requests; only ping is supported.
+func (c *MCPClient) serve(msg jsonrpcMessage) {
+	if len(msg.ID) == 0 {
+		return // notification
+	}
+	reply := jsonrpcMessage{JSONRPC: "2.0", ID: msg.ID}
+	if msg.Method == "ping" {
+		reply.Result = json.RawMessage("{}")
+	} else {
+		reply.Error = &jsonrpcError{Code: jsonrpcMethodNotFound, Message: "method not found: " + msg.Method}
+	}
+	go c.transport.send(context.Background(), reply)
+}
+
+// cancelRequest tells the server to stop working on an abandoned call.
+func (c *MCPClient) cancelRequest(id json.RawMessage) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	c.notify(ctx, "notifications/cancelled", map[string]any{"requestId": id, "reason": "the client stopped waiting"})
+}
+
+// ListTools returns every tool the server offers, following pagination.
+func (c *MCPClient) ListTools(ctx context.Context) ([]mcpTool, error) {
+	var tools []mcpTool
+	cursor := ""
+	for {
+		params := map[string]any{}
+		if cursor != "" {
+			params["cursor"] = cursor
+		}
+		var page struct {
+			Tools      []mcpTool `json:"tools"`
+			NextCursor string    `json:"nextCursor,omitempty"`
+		}
+		if err := c.call(ctx, "tools/list", params, &page); err != nil {
+			return nil, err
+		}
+		tools = append(tools, page.Tools...)
+		if page.NextCursor == "" {
+			return tools, nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+func (c *MCPClient) CallTool(ctx context.Context, name string, arguments json.RawMessage) (mcpCallToolResult, error) {
+	var result mcpCallToolResult
+	params := map[string]any{"name": name, "arguments": arguments}
+	err := c.call(ctx, "tools/call", params, &result)
+	return result, err
+}
+
+// ListResources returns the server's resources, or nil if it doesn't
+// support them.
+func (c *MCPClient) ListResources(ctx context.Context) ([]mcpResource, error) {
+	if _, ok := c.serverInfo.Capabilities["resources"]; !ok {
+		return nil, nil
+	}
+	var resources []mcpResource
+	cursor := ""
+	for {
+		params := map[string]any{}
+		if cursor != "" {
+			params["cursor"] = cursor
+		}
+		var page struct {
+			Resources  []mcpResource `json:"resources"`
+			NextCursor string        `json:"nextCursor,omitempty"`
+		}
+		if err := c.call(ctx, "resources/list", params, &page); err != nil {
+			return nil, err
+		}
+		resources = append(resources, page.Resources...)
+		if page.NextCursor == "" {
+			return resources, nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+func (c *MCPClient) ReadResource(ctx context.Context, uri string) ([]mcpResource, error) {
+	var result struct {
+		Contents []mcpResource `json:"contents"`
+	}
+	err := c.call(ctx, "resources/read", map[string]any{"uri": uri}, &result)
+	return result.Contents, err
+}
+
+var invalidToolNameChars = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// mcpToolName namespaces a server's tool so it can't collide with built-in
+// tools or other servers, within the 64 characters providers allow.
+func mcpToolName(server, tool string) string {
+	name := "mcp__" + invalidToolNameChars.ReplaceAllString(server, "_") + "__" + invalidToolNameChars.ReplaceAllString(tool, "_")
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
+
+// toolDefinitions converts the server's tools into ToolDefinitions whose
+// Function proxies the call to the server.
+func (c *MCPClient) toolDefinitions(tools []mcpTool) []ToolDefinition {
+	var defs []ToolDefinition
+	for _, tool := range tools {
+		schema := tool.InputSchema
+		if schema == nil {
+			schema = map[string]any{}
+		}
+		schema["type"] = "object"
+		if _, ok := schema["properties"]; !ok {
+			schema["properties"] = map[string]any{}
+		}
+		delete(schema, "$schema")
+
+		description := tool.Description
+		if description == "" {
+			description = tool.Name
+		}
+
+		toolName := tool.Name
+		defs = append(defs, ToolDefinition{
+			Name:        mcpToolName(c.name, tool.Name),
+			Description: fmt.Sprintf("[MCP server %s] %s", c.name, description),
+			InputSchema: schema,
+			ReadOnly:    tool.Annotations != nil && tool.Annotations.ReadOnlyHint,
+			Function: func(input json.RawMessage) (string, error) {
+				ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+				defer cancel()
+				result, err := c.CallTool(ctx, toolName, input)
+				if err != nil {
+					return "", err
+				}
+				text := formatMCPContent(result.Content)
+				if result.IsError {
+					return "", errors.New(text)
+				}
+				return text, nil
+			},
+		})
+	}
+	return defs
+}
+
+func formatMCPContent(content []mcpContent) string {
+	var parts []string
+	for _, item := range content {
+		switch item.Type {
+		case "text":
+			parts = append(parts, item.Text)
+		case "resource":
+			if item.Resource != nil {
+				parts = append(parts, formatMCPResource(*item.Resource))
+			}
+		default:
+			parts = append(parts, fmt.Sprintf("[%s content, %s]", item.Type, item.MimeType))
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+func formatMCPResource(r mcpResource) string {
+	if r.Text != "" {
+		return r.Text
+	}
+	if r.Blob != "" {
+		return fmt.Sprintf("[binary resource %s, %s, %d base64 bytes]", r.URI, r.MimeType, len(r.Blob))
+	}
+	return fmt.Sprintf("[empty resource %s]", r.URI)
+}
+
+// MCPManager owns the connections to every configured MCP server.
+type MCPManager struct {
+	clients map[string]*MCPClient
+	timeout time.Duration
+}
+
+// ConnectMCPServers connects to every enabled server in configs. Servers
+// that fail to start are skipped, so one broken server doesn't prevent the
+// agent from starting, and their errors are returned for the caller to
+// report.
+func ConnectMCPServers(ctx context.Context, configs map[string]MCPServerConfig, timeout time.Duration) (*MCPManager, []ToolDefinition, []error) {
+	m := &MCPManager{clients: map[string]*MCPClient{}, timeout: timeout}
+
+	names := make([]string, 0, len(configs))
+	for name := range configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var tools []ToolDefinition
+	var errs []error
+	for _, name := range names {
+		cfg := configs[name]
+		if cfg.Disabled {
+			continue
+		}
+		client, err := ConnectMCPServer(ctx, name, cfg, timeout)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("mcp server %s: %w", name, err))
+			continue
+		}
+		listCtx, cancel := context.WithTimeout(ctx, timeout)
+		serverTools, err := client.ListTools(listCtx)
+		cancel()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("mcp server %s: tools/list: %w", name, err))
+			client.Close()
+			continue
+		}
+		m.clients[name] = client
+		tools = append(tools, client.toolDefinitions(serverTools)...)
+	}
+
+	if len(m.clients) > 0 {
+		tools = append(tools, m.resourceToolDefinitions()...)
+	}
+	return m, tools, errs
+}
+
+func (m *MCPManager) Close() {
+	for _, client := range m.clients {
+		client.Close()
+	}
+}
+
+type MCPListResourcesInput struct {
+	Server string `json:"server,omitempty" jsonschema_description:"Optional MCP server name. Lists resources from every server if omitted."`
+}
+
+type MCPReadResourceInput struct {
+	Server string `json:"server" jsonschema_description:"The MCP server that owns the resource"`
+	URI    string `json:"uri" jsonschema_description:"The resource URI, as returned by mcp_list_resources"`
+}
+
+// resourceToolDefinitions exposes server resources to the model as context
+// it can list and read on demand.
+func (m *MCPManager) resourceToolDefinitions() []ToolDefinition {
+	return []ToolDefinition{
+		{
+			Name:        "mcp_list_resources",
+			Description: "List the resources (documents, schemas, records, ...) offered by the connected MCP servers.",
+			InputSchema: GenerateSchema[MCPListResourcesInput](),
+			Function:    m.listResources,
+			ReadOnly:    true,
+		},
+		{
+			Name:        "mcp_read_resource",
+			Description: "Read the contents of a resource offered by a connected MCP server.",
+			InputSchema: GenerateSchema[MCPReadResourceInput](),
+			Function:    m.readResource,
+			ReadOnly:    true,
+		},
+	}
+}
+
+func (m *MCPManager) listResources(input json.RawMessage) (string, error) {
+	listInput := MCPListResourcesInput{}
+	if err := json.Unmarshal(input, &listInput); err != nil {
+		return "", err
+	}
+
+	names := make([]string, 0, len(m.clients))
+	for name := range m.clients {
+		if listInput.Server == "" || listInput.Server == name {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "", fmt.Errorf("unknown mcp server: %s", listInput.Server)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+		resources, err := m.clients[name].ListResources(ctx)
+		cancel()
+		if err != nil {
+			fmt.Fprintf(&b, "%s: error: %s\n", name, err)
+			continue
+		}
+		for _, r := range resources {
+			fmt.Fprintf(&b, "%s: %s", name, r.URI)
+			if r.Name != "" {
+				fmt.Fprintf(&b, " (%s)", r.Name)
+			}
+			if r.Description != "" {
+				fmt.Fprintf(&b, " - %s", r.Description)
+			}
+			b.WriteString("\n")
+		}
+	}
+	if b.Len() == 0 {
+		return "No resources available.", nil
+	}
+	return b.String(), nil
+}
+
+func (m *MCPManager) readResource(input json.RawMessage) (string, error) {
+	readInput := MCPReadResourceInput{}
+	if err := json.Unmarshal(input, &readInput); err != nil {
+		return "", err
+	}
+	client, ok := m.clients[readInput.Server]
+	if !ok {
+		return "", fmt.Errorf("unknown mcp server: %s", readInput.Server)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+	contents, err := client.ReadResource(ctx, readInput.URI)
+	if err != nil {
+		return "", err
+	}
+
+	var parts []string
+	for _, r := range contents {
+		parts = append(parts, formatMCPResource(r))
+	}
+	return strings.Join(parts, "\n\n"), nil
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// mcpPathArguments are the names of tool arguments, at any depth, that name
+// files or directories and must therefore stay inside the served root.
+var mcpPathArguments = []string{"path", "old_path", "new_path", "folder_path"}
+
+// MCPServer exposes ToolDefinitions to an MCP client over stdio.
+type MCPServer struct {
+	tools      []ToolDefinition
+	root       string
+	maxResult  int
+	serverInfo mcpImplementation
+
+	out io.Writer
+	mu  sync.Mutex // serializes writes to out
+	wg  sync.WaitGroup
+}
+
+// runMCPServe implements `agent mcp-serve [-root dir]`. The process changes
+// into root so the tools' relative paths resolve there, and every path
+// argument is checked against it before a tool runs.
+func runMCPServe(cfg Config, args []string) error {
+	flags := flag.NewFlagSet("mcp-serve", flag.ContinueOnError)
+	root := flags.String("root", ".", "directory the exposed tools are confined to")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	absRoot, err := filepath.Abs(*root)
+	if err != nil {
+		return err
+	}
+	if absRoot, err = filepath.EvalSymlinks(absRoot); err != nil {
+		return err
+	}
+	if err := os.Chdir(absRoot); err != nil {
+		return err
+	}
+	terminalRunTimeout = cfg.Timeouts.TerminalRun
+
+	// Denied tools are not offered at all; "ask" is left to the client,
+	// which is expected to confirm calls with its own user. Tools that act
+	// on a running agent have none to act on here.
+	var tools []ToolDefinition
+	web := NewWebClient(cfg.Web, cfg.Timeouts.WebFetch)
+	for _, tool := range cfg.FilterTools(slices.Concat(allTools, web.toolDefinitions())) {
+		if cfg.Permissions.ModeFor(tool.Name) != PermissionDeny && tool.agentFunction == nil {
+			tools = append(tools, tool)
+		}
+	}
+
+	server := &MCPServer{
+		tools:      tools,
+		root:       absRoot,
+		maxResult:  cfg.Output.MaxToolResultChars,
+		serverInfo: mcpImplementation{Name: "agent", Version: "1.0.0"},
+		out:        os.Stdout,
+	}
+	return server.Serve(os.Stdin)
+}
+
+// Serve handles newline-delimited JSON-RPC requests from r until it is
+// exhausted. Requests are handled concurrently so a slow tool call doesn't
+// block pings.
+func (s *MCPServer) Serve(r io.Reader) error {
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			var msg jsonrpcMessage
+			if jsonErr := json.Unmarshal(line, &msg); jsonErr != nil {
+				s.reply(jsonrpcMessage{
+					JSONRPC: "2.0",
+					ID:      json.RawMessage("null"),
+					Error:   &jsonrpcError{Code: jsonrpcParseError, Message: jsonErr.Error()},
+				})
+			} else if len(msg.ID) > 0 && msg.Method != "" {
+				s.wg.Add(1)
+				go func() {
+					defer s.wg.Done()
+					s.reply(s.handle(msg))
+				}()
+			}
+			// Notifications (no id) and stray responses need no reply
+		}
+		if err != nil {
+			s.wg.Wait()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+func (s *MCPServer) reply(msg jsonrpcMessage) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.out.Write(append(data, '\n'))
+}
+
+func (s *MCPServer) handle(msg jsonrpcMessage) jsonrpcMessage {
+	reply := jsonrpcMessage{JSONRPC: "2.0", ID: msg.ID}
+
+	var result any
+	var rpcErr *jsonrpcError
+	switch msg.Method {
+	case "initialize":
+		result = mcpInitializeResult{
+			ProtocolVersion: mcpProtocolVersion,
+			Capabilities:    map[string]any{"tools": map[string]any{}},
+			ServerInfo:      s.serverInfo,
+			Instructions:    fmt.Sprintf("File and terminal tools confined to %s. Paths are relative to that directory.", s.root),
+		}
+	case "ping":
+		result = map[string]any{}
+	case "tools/list":
+		result = s.listTools()
+	case "tools/call":
+		result, rpcErr = s.callTool(msg.Params)
+	default:
+		rpcErr = &jsonrpcError{Code: jsonrpcMethodNotFound, Message: "method not found: " + msg.Method}
+	}
+
+	if rpcErr != nil {
+		reply.Error = rpcErr
+		return reply
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		reply.Error = &jsonrpcError{Code: jsonrpcInternalError, Message: err.Error()}
+		return reply
+	}
+	reply.Result = data
+	return reply
+}
+
+func (s *MCPServer) listTools() map[string]any {
+	tools := make([]mcpTool, 0, len(s.tools))
+	for _, tool := range s.tools {
+		schema, _ := tool.InputSchema.(map[string]any)
+		var annotations *mcpToolAnnotations
+		if tool.ReadOnly {
+			annotations = &mcpToolAnnotations{ReadOnlyHint: true}
+		}
+		tools = append(tools, mcpTool{
+			Name:        tool.Name,
+			Description: tool.Description,
+			InputSchema: schema,
+			Annotations: annotations,
+		})
+	}
+	return map[string]any{"tools": tools}
+}
+
+func (s *MCPServer) callTool(params json.RawMessage) (any, *jsonrpcError) {
+	var call struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	}
+	if err := json.Unmarshal(params, &call); err != nil {
+		return nil, &jsonrpcError{Code: jsonrpcInvalidParams, Message: err.Error()}
+	}
+
+	var toolDef ToolDefinition
+	var found bool
+	for _, tool := range s.tools {
+		if tool.Name == call.Name {
+			toolDef = tool
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, &jsonrpcError{Code: jsonrpcInvalidParams, Message: "unknown tool: " + call.Name}
+	}
+
+	input := call.Arguments
+	if len(bytes.TrimSpace(input)) == 0 || string(input) == "null" {
+		input = json.RawMessage("{}")
+	}
+	if err := validateToolInput(toolDef, input); err != nil {
+		return toolErrorResult(err), nil
+	}
+	if err := s.confineArguments(input); err != nil {
+		return toolErrorResult(err), nil
+	}
+
+	response, err := toolDef.Function(input)
+	if err != nil {
+		return toolErrorResult(err), nil
+	}
+	return mcpCallToolResult{
+		Content: []mcpContent{{Type: "text", Text: truncateToolResult(response, s.maxResult)}},
+	}, nil
+}
+
+func toolErrorResult(err error) mcpCallToolResult {
+	return mcpCallToolResult{
+		Content: []mcpContent{{Type: "text", Text: err.Error()}},
+		IsError: true,
+	}
+}
+
+// confineArguments rejects path arguments that resolve outside the root,
+// wherever they appear in the input, such as in multi_edit's edits.
+func (s *MCPServer) confineArguments(input json.RawMessage) error {
+	var args any
+	if err := json.Unmarshal(input, &args); err != nil {
+		return err
+	}
+	return s.confineValue("", args)
+}
+
+func (s *MCPServer) confineValue(name string, value any) error {
+	switch value := value.(type) {
+	case map[string]any:
+		for key, field := range value {
+			fieldName := key
+			if name != "" {
+				fieldName = name + "." + key
+			}
+			if p, ok := field.(string); ok && p != "" && slices.Contains(mcpPathArguments, key) {
+				if err := confinePath(s.root, p); err != nil {
+					return fmt.Errorf("%s: %w", fieldName, err)
+				}
+				continue
+			}
+			if err := s.confineValue(fieldName, field); err != nil {
+				return err
+			}
+		}
+	case []any:
+		for i, element := range value {
+			if err := s.confineValue(fmt.Sprintf("%s[%d]", name, i), element); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// confinePath reports an error if p, resolved against root and with any
+// symlinks in its existing ancestors followed, lies outside root.
+func confinePath(root, p string) error {
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(root, p)
+	}
+	p = filepath.Clean(p)
+
+	// Resolve symlinks on the longest prefix that exists; the rest of the
+	// path will be created by the tool and cannot be a link yet.
+	existing, rest := p, ""
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			break
+		}
+		rest = filepath.Join(filepath.Base(existing), rest)
+		existing = parent
+	}
+	if resolved, err := filepath.EvalSymlinks(existing); err == nil {
+		p = filepath.Join(resolved, rest)
+	}
+
+	rel, err := filepath.Rel(root, p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("path %s is outside the served root %s", p, root)
+	}
+	return nil
+}
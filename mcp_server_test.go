@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMCPServerConfinesPaths(t *testing.T) {
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+	server := &MCPServer{root: root}
+
+	tests := []struct {
+		tool    string
+		input   string
+		wantErr string
+	}{
+		{"create_file", `{"path": "notes/a.txt", "content": "../not-a-path"}`, ""},
+		{"create_file", `{"path": "../outside.txt"}`, "path: path"},
+		{"create_file", `{"path": "` + filepath.Join(outside, "a.txt") + `"}`, "path: path"},
+		{"create_file", `{"path": "link/a.txt"}`, "path: path"},
+		{"rename_file", `{"old_path": "a.txt", "new_path": "../b.txt"}`, "new_path: path"},
+		{"create_website", `{"folder_path": "../site"}`, "folder_path: path"},
+		{"multi_edit", `{"edits": [{"path": "a.txt", "old_str": "", "new_str": "x"}, {"path": "b/c.txt"}]}`, ""},
+		{"multi_edit", `{"edits": [{"path": "a.txt"}, {"path": "../outside.txt", "old_str": "", "new_str": "x"}]}`, "edits[1].path: path"},
+	}
+	for _, tt := range tests {
+		err := server.confineArguments([]byte(tt.input))
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s %s: unexpected error %v", tt.tool, tt.input, err)
+			}
+			continue
+		}
+		if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) || !strings.Contains(err.Error(), "outside the served root") {
+			t.Errorf("%s %s: got error %v, want %q outside the served root", tt.tool, tt.input, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// mcpTransport carries JSON-RPC messages to and from an MCP server. Messages
+// received from the server, including responses, are handed to the deliver
+// callback passed to start, so every transport looks asynchronous to the
+// client even when the underlying protocol is request/response.
+type mcpTransport interface {
+	start(ctx context.Context, deliver func(jsonrpcMessage)) error
+	send(ctx context.Context, msg jsonrpcMessage) error
+	close() error
+}
+
+// newMCPTransport picks the transport for a configured server.
+func newMCPTransport(cfg MCPServerConfig) (mcpTransport, error) {
+	transport := cfg.Transport
+	if transport == "" {
+		if cfg.Command != "" {
+			transport = "stdio"
+		} else {
+			transport = "http"
+		}
+	}
+
+	switch transport {
+	case "stdio":
+		if cfg.Command == "" {
+			return nil, errors.New("stdio transport requires a command")
+		}
+		return &mcpStdioTransport{cfg: cfg}, nil
+	case "http":
+		if cfg.URL == "" {
+			return nil, errors.New("http transport requires a url")
+		}
+		return &mcpHTTPTransport{url: cfg.URL, headers: expandEnvMap(cfg.Headers), client: http.DefaultClient}, nil
+	case "sse":
+		if cfg.URL == "" {
+			return nil, errors.New("sse transport requires a url")
+		}
+		return &mcpSSETransport{url: cfg.URL, headers: expandEnvMap(cfg.Headers), client: http.DefaultClient}, nil
+	default:
+		return nil, fmt.Errorf("unknown transport %q (want stdio, http or sse)", transport)
+	}
+}
+
+func expandEnvMap(m map[string]string) map[string]string {
+	expanded := make(map[string]string, len(m))
+	for k, v := range m {
+		expanded[k] = os.ExpandEnv(v)
+	}
+	return expanded
+}
+
+// mcpStdioTransport runs the server as a child process speaking
+// newline-delimited JSON-RPC over its stdin and stdout.
+type mcpStdioTransport struct {
+	cfg MCPServerConfig
+
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stderr *tailBuffer
+	mu     sync.Mutex // serializes writes to stdin
+}
+
+func (t *mcpStdioTransport) start(ctx context.Context, deliver func(jsonrpcMessage)) error {
+	cmd := exec.Command(t.cfg.Command, t.cfg.Args...)
+	cmd.Env = os.Environ()
+	for k, v := range expandEnvMap(t.cfg.Env) {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	t.stderr = &tailBuffer{limit: 4096}
+	cmd.Stderr = t.stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start %s: %w", t.cfg.Command, err)
+	}
+	t.cmd = cmd
+	t.stdin = stdin
+
+	go func() {
+		readJSONLines(stdout, deliver)
+		deliver(jsonrpcMessage{closed: fmt.Errorf("server exited%s", t.stderr.suffix())})
+	}()
+	return nil
+}
+
+func (t *mcpStdioTransport) send(ctx context.Context, msg jsonrpcMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, err = t.stdin.Write(append(data, '\n'))
+	return err
+}
+
+func (t *mcpStdioTransport) close() error {
+	if t.cmd == nil {
+		return nil
+	}
+	t.stdin.Close()
+
+	done := make(chan error, 1)
+	go func() { done <- t.cmd.Wait() }()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(2 * time.Second):
+		t.cmd.Process.Kill()
+		return <-done
+	}
+}
+
+// readJSONLines decodes one JSON-RPC message per line until r is exhausted.
+// Lines that are not valid JSON (stray logging) are skipped.
+func readJSONLines(r io.Reader, deliver func(jsonrpcMessage)) {
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			var msg jsonrpcMessage
+			if json.Unmarshal(line, &msg) == nil {
+				deliver(msg)
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// tailBuffer keeps the last limit bytes written to it, so a crashing server's
+// final stderr output can be shown without buffering everything it logs.
+type tailBuffer struct {
+	mu    sync.Mutex
+	limit int
+	buf   []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf = append(b.buf, p...)
+	if over := len(b.buf) - b.limit; over > 0 {
+		b.buf = b.buf[over:]
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) suffix() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if s := strings.TrimSpace(string(b.buf)); s != "" {
+		return ": " + s
+	}
+	return ""
+}
+
+// mcpHTTPTransport implements the streamable HTTP transport: every message
+// is POSTed to a single endpoint and the reply arrives either as a JSON body
+// or as an event stream.
+type mcpHTTPTransport struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+
+	deliver   func(jsonrpcMessage)
+	mu        sync.Mutex
+	sessionID string
+}
+
+func (t *mcpHTTPTransport) start(ctx context.Context, deliver func(jsonrpcMessage)) error {
+	t.deliver = deliver
+	return nil
+}
+
+func (t *mcpHTTPTransport) send(ctx context.Context, msg jsonrpcMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+	t.mu.Lock()
+	if t.sessionID != "" {
+		req.Header.Set("Mcp-Session-Id", t.sessionID)
+	}
+	t.mu.Unlock()
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	if id := resp.Header.Get("Mcp-Session-Id"); id != "" {
+		t.mu.Lock()
+		t.sessionID = id
+		t.mu.Unlock()
+	}
+	if resp.StatusCode >= 400 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		return fmt.Errorf("HTTP %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		go func() {
+			defer resp.Body.Close()
+			readSSE(resp.Body, func(event, data string) {
+				var reply jsonrpcMessage
+				if json.Unmarshal([]byte(data), &reply) == nil {
+					t.deliver(reply)
+				}
+			})
+		}()
+		return nil
+	}
+
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil // 202 Accepted for notifications
+	}
+	var reply jsonrpcMessage
+	if err := json.Unmarshal(body, &reply); err != nil {
+		return fmt.Errorf("invalid response from server: %w", err)
+	}
+	t.deliver(reply)
+	return nil
+}
+
+func (t *mcpHTTPTransport) close() error {
+	t.mu.Lock()
+	sessionID := t.sessionID
+	t.mu.Unlock()
+	if sessionID == "" {
+		return nil
+	}
+	req, err := http.NewRequest(http.MethodDelete, t.url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Mcp-Session-Id", sessionID)
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// mcpSSETransport implements the older HTTP+SSE transport: a long-lived GET
+// stream carries server messages, and its first "endpoint" event names the
+// URL that client messages are POSTed to.
+type mcpSSETransport struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+
+	endpoint string
+	cancel   context.CancelFunc
+}
+
+func (t *mcpSSETransport) start(ctx context.Context, deliver func(jsonrpcMessage)) error {
+	streamCtx, cancel := context.WithCancel(context.Background())
+	t.cancel = cancel
+
+	req, err := http.NewRequestWithContext(streamCtx, http.MethodGet, t.url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 400 {
+		resp.Body.Close()
+		return fmt.Errorf("HTTP %s", resp.Status)
+	}
+
+	endpoint := make(chan string, 1)
+	go func() {
+		defer resp.Body.Close()
+		readSSE(resp.Body, func(event, data string) {
+			switch event {
+			case "endpoint":
+				select {
+				case endpoint <- data:
+				default:
+				}
+			case "", "message":
+				var msg jsonrpcMessage
+				if json.Unmarshal([]byte(data), &msg) == nil {
+					deliver(msg)
+				}
+			}
+		})
+		deliver(jsonrpcMessage{closed: errors.New("event stream closed")})
+	}()
+
+	select {
+	case e := <-endpoint:
+		base, err := url.Parse(t.url)
+		if err != nil {
+			return err
+		}
+		ref, err := url.Parse(e)
+		if err != nil {
+			return fmt.Errorf("invalid endpoint event %q: %w", e, err)
+		}
+		t.endpoint = base.ResolveReference(ref).String()
+		return nil
+	case <-ctx.Done():
+		cancel()
+		return fmt.Errorf("waiting for endpoint event: %w", ctx.Err())
+	}
+}
+
+func (t *mcpSSETransport) send(ctx context.Context, msg jsonrpcMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("HTTP %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+func (t *mcpSSETransport) close() error {
+	if t.cancel != nil {
+		t.cancel()
+	}
+	return nil
+}
+
+// readSSE parses a text/event-stream body, calling fn for every dispatched
+// event with its type and data (multi-line data joined by newlines).
+func readSSE(r io.Reader, fn func(event, data string)) error {
+	reader := bufio.NewReader(r)
+	var event string
+	var data []string
+	for {
+		line, err := reader.ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+
+		switch {
+		case line == "":
+			if len(data) > 0 {
+				fn(event, strings.Join(data, "\n"))
+			}
+			event, data = "", nil
+		case strings.HasPrefix(line, ":"):
+			// comment / keep-alive
+		default:
+			field, value, _ := strings.Cut(line, ":")
+			value = strings.TrimPrefix(value, " ")
+			switch field {
+			case "event":
+				event = value
+			case "data":
+				data = append(data, value)
+			}
+		}
+
+		if err != nil {
+			if len(data) > 0 {
+				fn(event, strings.Join(data, "\n"))
+			}
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// Limits on what @-mentions inline into a message. Whatever doesn't fit is
+// left for the model to read with read_file or list_files.
+const (
+	maxMentionFileBytes  = 50 * 1024
+	maxMentionTotalBytes = 200 * 1024
+	maxMentionEntries    = 200
+)
+
+// mention matches "@path" words in user input.
+var mention = regexp.MustCompile(`(?:^|\s)@(\S+)`)
+
+var imageExtension = regexp.MustCompile(`(?i)\.(png|jpe?g|gif|webp)$`)
+
+// mentionedPath returns the existing file or directory an @-mention names,
+// ignoring punctuation that ends the sentence around it.
+func mentionedPath(word string) (string, os.FileInfo, bool) {
+	for _, path := range []string{word, strings.TrimRight(word, `.,;:!?)]}'"`)} {
+		if info, err := os.Stat(path); err == nil && path != "" {
+			return filepath.Clean(path), info, true
+		}
+	}
+	return "", nil, false
+}
+
+// userMessage builds the message for what the user typed. Files mentioned
+// as @path are inlined after the text, directories as @dir/ are listed, and
+// images are attached, which fails if the model cannot see them. Mentions
+// of paths that don't exist are left as plain text. It also returns a short
+// description of each attachment for the transcript.
+func (a *Agent) userMessage(text string) (openai.ChatCompletionMessage, []string, error) {
+	message := openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: text}
+
+	var blocks, attachments []string
+	var images []openai.ChatMessagePart
+	budget := maxMentionTotalBytes
+	seen := map[string]bool{}
+	for _, match := range mention.FindAllStringSubmatch(text, -1) {
+		path, info, ok := mentionedPath(match[1])
+		if !ok || seen[path] {
+			continue
+		}
+		seen[path] = true
+
+		switch {
+		case info.IsDir():
+			listing, entries := listMentionedDir(path)
+			blocks = append(blocks, fmt.Sprintf("<directory path=%q>\n%s\n</directory>", path+"/", listing))
+			noun := "entries"
+			if entries == 1 {
+				noun = "entry"
+			}
+			attachments = append(attachments, fmt.Sprintf("%s/ (%d %s)", path, entries, noun))
+			budget -= len(listing)
+
+		case imageExtension.MatchString(path):
+			if !a.supportsVision() {
+				return message, nil, a.noVisionError()
+			}
+			part, description, err := loadImage(path)
+			if err != nil {
+				return message, nil, err
+			}
+			images = append(images, part)
+			attachments = append(attachments, description)
+
+		case budget <= 0:
+			attachments = append(attachments, path+" (not inlined: size limit reached)")
+
+		default:
+			content, description, err := readMentionedFile(path, min(maxMentionFileBytes, budget))
+			if err != nil {
+				return message, nil, err
+			}
+			attachments = append(attachments, description)
+			if content == "" {
+				continue
+			}
+			blocks = append(blocks, fmt.Sprintf("<file path=%q>\n%s\n</file>", path, strings.TrimSuffix(content, "\n")))
+			budget -= len(content)
+			if int64(len(content)) == info.Size() {
+				a.files.record(path) // the model has seen the whole file
+			}
+		}
+	}
+
+	if len(blocks) > 0 {
+		text += "\n\nContents of the files and directories mentioned above:\n\n" + strings.Join(blocks, "\n\n")
+	}
+	if len(images) > 0 {
+		message.Content = ""
+		message.MultiContent = append([]openai.ChatMessagePart{{Type: openai.ChatMessagePartTypeText, Text: text}}, images...)
+	} else {
+		message.Content = text
+	}
+	return message, attachments, nil
+}
+
+// readMentionedFile returns the text of path, cut at a line boundary to at
+// most limit bytes, and a description for the transcript. Binary files
+// have no content.
+func readMentionedFile(path string, limit int) (string, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", err
+	}
+	if bytes.IndexByte(data, 0) >= 0 {
+		return "", path + " (binary, not inlined)", nil
+	}
+	if len(data) <= limit {
+		return string(data), fmt.Sprintf("%s (%s)", path, formatSize(len(data))), nil
+	}
+
+	cut := data[:limit]
+	if i := bytes.LastIndexByte(cut, '\n'); i > 0 {
+		cut = cut[:i+1]
+	}
+	total := bytes.Count(data, []byte("\n"))
+	if !bytes.HasSuffix(data, []byte("\n")) {
+		total++
+	}
+	content := fmt.Sprintf("%s[truncated after line %d of %d; use read_file for the rest]", cut, bytes.Count(cut, []byte("\n")), total)
+	return content, fmt.Sprintf("%s (first %s of %s)", path, formatSize(len(cut)), formatSize(len(data))), nil
+}
+
+// listMentionedDir lists the files and directories under dir, skipping
+// hidden directories like search_files does, and returns the listing and
+// the number of entries in it.
+func listMentionedDir(dir string) (string, int) {
+	var entries []string
+	truncated := false
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == dir {
+			return nil
+		}
+		if d.IsDir() && strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if len(entries) == maxMentionEntries {
+			truncated = true
+			return filepath.SkipAll
+		}
+		rel, _ := filepath.Rel(dir, path)
+		if d.IsDir() {
+			rel += "/"
+		}
+		entries = append(entries, filepath.ToSlash(rel))
+		return nil
+	})
+
+	listing := strings.Join(entries, "\n")
+	if len(entries) == 0 {
+		listing = "(empty)"
+	}
+	if truncated {
+		listing += fmt.Sprintf("\n[stopped after %d entries; use list_files for the rest]", maxMentionEntries)
+	}
+	return listing, len(entries)
+}
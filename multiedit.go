@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+var MultiEditDefinition = ToolDefinition{
+	Name: "multi_edit",
+	Description: `Apply several edits, across one or more files, as one all-or-nothing change. Use this for refactors that must stay consistent.
+
+Each edit works like edit_file: 'old_str' is replaced with 'new_str' in 'path', and an empty 'old_str' creates a file that does not exist yet. Edits to the same file apply in order, each to the result of the previous one. Every edit is checked before anything is written; if any edit fails, no file is changed.`,
+	InputSchema: GenerateSchema[MultiEditInput](),
+	Function:    MultiEdit,
+	Preview:     PreviewMultiEdit,
+}
+
+type MultiEditInput struct {
+	Edits []EditFileInput `json:"edits" jsonschema:"required" jsonschema_description:"The edits to apply, in order"`
+}
+
+// plannedFile is the outcome of a batch of edits on one file, computed in
+// memory before anything is written.
+type plannedFile struct {
+	path     string
+	existed  bool
+	original []byte
+	content  string
+
+	createdDirs []string // made when the file was written, deepest first
+}
+
+// planMultiEdit applies edits in memory. It returns the resulting files in
+// the order they were first edited and a report line per edit; err is set
+// if any edit failed.
+func planMultiEdit(edits []EditFileInput) ([]*plannedFile, []string, error) {
+	if len(edits) == 0 {
+		return nil, nil, fmt.Errorf("edits cannot be empty")
+	}
+
+	var files []*plannedFile
+	byPath := map[string]*plannedFile{}
+	report := make([]string, len(edits))
+	failed := 0
+
+	for i, edit := range edits {
+		fail := func(err error) {
+			report[i] = fmt.Sprintf("%d. %s: FAILED: %v", i+1, edit.Path, err)
+			failed++
+		}
+		if edit.Path == "" {
+			fail(fmt.Errorf("path cannot be empty"))
+			continue
+		}
+		if edit.OldStr == edit.NewStr {
+			fail(fmt.Errorf("old_str and new_str must be different"))
+			continue
+		}
+
+		// Different spellings of the same path must share one plan, or the
+		// second write would clobber the first
+		key, err := filepath.Abs(edit.Path)
+		if err != nil {
+			fail(err)
+			continue
+		}
+		file, ok := byPath[key]
+		if !ok {
+			file = &plannedFile{path: edit.Path}
+			content, err := os.ReadFile(edit.Path)
+			switch {
+			case err == nil:
+				file.existed = true
+				file.original = content
+				file.content = string(content)
+			case !os.IsNotExist(err):
+				fail(err)
+				continue
+			case edit.OldStr != "":
+				fail(fmt.Errorf("file does not exist"))
+				continue
+			}
+			byPath[key] = file
+			files = append(files, file)
+		}
+
+		content, err := applyEdit(file.content, edit.OldStr, edit.NewStr)
+		if err != nil {
+			fail(err)
+			continue
+		}
+		file.content = content
+		report[i] = fmt.Sprintf("%d. %s: OK", i+1, edit.Path)
+	}
+
+	if failed > 0 {
+		return nil, report, fmt.Errorf("%d of %d edits failed", failed, len(edits))
+	}
+	return files, report, nil
+}
+
+func MultiEdit(input json.RawMessage) (string, error) {
+	multiEditInput := MultiEditInput{}
+	if err := json.Unmarshal(input, &multiEditInput); err != nil {
+		return "", err
+	}
+
+	files, report, err := planMultiEdit(multiEditInput.Edits)
+	if err != nil {
+		return "", fmt.Errorf("%w; no files were changed:\n%s", err, strings.Join(report, "\n"))
+	}
+
+	for i, file := range files {
+		if err := writePlannedFile(file); err != nil {
+			rollbackErr := rollbackPlannedFiles(files[:i])
+			message := fmt.Sprintf("failed to write %s: %v; the %d files already written were restored", file.path, err, i)
+			if rollbackErr != nil {
+				message = fmt.Sprintf("failed to write %s: %v; restoring the files already written also failed: %v", file.path, err, rollbackErr)
+			}
+			return "", fmt.Errorf("%s:\n%s", message, strings.Join(report, "\n"))
+		}
+	}
+
+	return fmt.Sprintf("Applied %d edits to %d files:\n%s", len(report), len(files), strings.Join(report, "\n")), nil
+}
+
+func writePlannedFile(file *plannedFile) error {
+	if !file.existed {
+		// Note the missing directories so that a rollback can remove them
+		var missing []string
+		for dir := filepath.Dir(file.path); dir != "." && dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+			if _, err := os.Stat(dir); err == nil {
+				break
+			}
+			missing = append(missing, dir)
+		}
+		if len(missing) > 0 {
+			if err := os.MkdirAll(missing[0], 0755); err != nil {
+				return fmt.Errorf("failed to create directory: %w", err)
+			}
+			file.createdDirs = missing
+		}
+	}
+	err := writeFileAtomic(file.path, []byte(file.content), 0644)
+	if err != nil {
+		for _, dir := range file.createdDirs {
+			os.Remove(dir)
+		}
+		file.createdDirs = nil
+	}
+	return err
+}
+
+// rollbackPlannedFiles restores files to their state before the batch,
+// removing the ones it created and the directories made for them. Files are
+// restored in reverse order, so that directories nested in ones made for an
+// earlier file are gone before it is removed.
+func rollbackPlannedFiles(files []*plannedFile) error {
+	var errs []string
+	for _, file := range slices.Backward(files) {
+		var err error
+		if file.existed {
+			err = writeFileAtomic(file.path, file.original, 0644)
+		} else {
+			err = os.Remove(file.path)
+			for _, dir := range file.createdDirs {
+				if err == nil {
+					err = os.Remove(dir)
+				}
+			}
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", file.path, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func PreviewMultiEdit(input json.RawMessage) ([]FileChange, error) {
+	multiEditInput := MultiEditInput{}
+	if err := json.Unmarshal(input, &multiEditInput); err != nil {
+		return nil, invalidInput(err)
+	}
+
+	// A batch that fails to plan writes nothing, whatever the reason
+	files, _, err := planMultiEdit(multiEditInput.Edits)
+	if err != nil {
+		return nil, invalidInput(err)
+	}
+	changes := make([]FileChange, 0, len(files))
+	for _, file := range files {
+		change, err := newFileChange(file.path, file.content)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, *change)
+	}
+	return changes, nil
+}
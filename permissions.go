@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// PermissionMode decides whether a tool call may run.
+type PermissionMode string
+
+const (
+	PermissionAllow PermissionMode = "allow" // run without asking
+	PermissionAsk   PermissionMode = "ask"   // ask the user before each call
+	PermissionDeny  PermissionMode = "deny"  // never run; the model is told why
+)
+
+// PermissionConfig is the tool permission policy. Tools lists per-tool
+// overrides of the default mode, keyed by tool name. With ReviewEdits set,
+// every file change is shown as a diff and applied only once approved.
+type PermissionConfig struct {
+	Default     PermissionMode            `yaml:"default"`
+	Tools       map[string]PermissionMode `yaml:"tools,omitempty"`
+	ReviewEdits bool                      `yaml:"review_edits"`
+}
+
+func (p PermissionConfig) Validate() error {
+	if !p.Default.valid() {
+		return fmt.Errorf("invalid default permission %q (want allow, ask or deny)", p.Default)
+	}
+	for name, mode := range p.Tools {
+		if !mode.valid() {
+			return fmt.Errorf("invalid permission %q for tool %s (want allow, ask or deny)", mode, name)
+		}
+	}
+	return nil
+}
+
+func (m PermissionMode) valid() bool {
+	return m == PermissionAllow || m == PermissionAsk || m == PermissionDeny
+}
+
+// ModeFor returns the permission mode that applies to the named tool.
+func (p PermissionConfig) ModeFor(name string) PermissionMode {
+	if mode, ok := p.Tools[name]; ok {
+		return mode
+	}
+	if p.Default == "" {
+		return PermissionAllow
+	}
+	return p.Default
+}
+
+// checkPermission applies the permission policy to a tool call. It returns
+// "" when the call may proceed, or the message to send back to the model.
+// File changes are announced before they are made; in review mode
+// approving their diffs takes the place of approving the call, and
+// rejecting any of them denies it.
+func (a *Agent) checkPermission(id, name string, input json.RawMessage, changes []FileChange) string {
+	mode := a.permissions.ModeFor(name)
+	if mode == PermissionDeny {
+		return fmt.Sprintf("permission denied: tool %s is disabled by the permission policy", name)
+	}
+
+	for _, change := range changes {
+		a.emit(FileChangeEvent{ID: id, Tool: name, FileChange: change})
+		if a.reviewEdits && !a.approver.ReviewFileChange(id, change) {
+			a.logFileChanges(id, name, "rejected", changes)
+			return fmt.Sprintf("permission denied: the user rejected the change to %s", change.Path)
+		}
+	}
+	if len(changes) > 0 && a.reviewEdits {
+		return ""
+	}
+
+	switch mode {
+	case PermissionAsk:
+		if !a.approver.ApproveToolCall(id, name, input) {
+			a.logFileChanges(id, name, "rejected", changes)
+			return fmt.Sprintf("permission denied: the user declined to run %s", name)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// planModePrompt is sent as a system message while the agent is in plan
+// mode. It is not part of the conversation, so it disappears once the plan
+// is approved.
+const planModePrompt = `You are in plan mode. Investigate the task using only the read-only tools available to you; you cannot edit files or run commands yet.
+When you understand what needs to be done, reply with a written plan: the files to change, the changes to make in each, and how to verify them. The user will review the plan and either approve it or ask you to revise it.`
+
+// planApprovedMessage tells the model it may now carry out its plan.
+const planApprovedMessage = "The plan is approved. Carry it out now; all tools are available."
+
+// availableTools returns the tools the model may use in the current mode.
+func (a *Agent) availableTools() []ToolDefinition {
+	if !a.planMode {
+		return a.tools
+	}
+	var tools []ToolDefinition
+	for _, tool := range a.tools {
+		if tool.ReadOnly {
+			tools = append(tools, tool)
+		}
+	}
+	return tools
+}
+
+// withPlanModePrompt prepends the plan mode instructions when plan mode is on.
+func (a *Agent) withPlanModePrompt(conversation []openai.ChatCompletionMessage) []openai.ChatCompletionMessage {
+	if !a.planMode {
+		return conversation
+	}
+	return append([]openai.ChatCompletionMessage{{
+		Role:    openai.ChatMessageRoleSystem,
+		Content: planModePrompt,
+	}}, conversation...)
+}
+
+// planModeDenial is returned for tools that exist but are hidden in plan mode.
+func planModeDenial(name string) string {
+	return fmt.Sprintf("tool %s is not available in plan mode; only read-only tools can be used until the user approves your plan", name)
+}
+
+// reviewPlan asks the user to approve plan. On approval the agent leaves
+// plan mode and is told to carry the plan out; it reports whether the turn
+// should continue.
+func (a *Agent) reviewPlan(plan string) bool {
+	if !a.approver.ApprovePlan(plan) {
+		return false
+	}
+	a.planMode = false
+	a.appendMessage(openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleUser,
+		Content: planApprovedMessage,
+	})
+	return true
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// PostWriteHook declares commands to run on every file matching Pattern
+// after a tool writes it. Format rewrites the file in place; Lint checks it
+// and reports its output to the model when it exits non-zero. In both
+// commands {file} is replaced with the file's path and {dir} with its
+// directory, quoted for the shell.
+type PostWriteHook struct {
+	Pattern string        `yaml:"pattern"`
+	Format  string        `yaml:"format,omitempty"`
+	Lint    string        `yaml:"lint,omitempty"`
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+}
+
+const (
+	defaultPostWriteTimeout = 30 * time.Second
+	maxPostWriteOutput      = 4000
+)
+
+// matches reports whether the hook applies to path. Patterns without a
+// slash match the file name in any directory; others match the whole path.
+func (h PostWriteHook) matches(path string) bool {
+	path = filepath.ToSlash(filepath.Clean(path))
+	if !strings.Contains(h.Pattern, "/") {
+		path = filepath.Base(path)
+	}
+	ok, _ := filepath.Match(h.Pattern, path)
+	return ok
+}
+
+func (h PostWriteHook) expand(command, path string) string {
+	dir := filepath.Dir(path)
+	if !filepath.IsAbs(dir) && !strings.HasPrefix(dir, ".") {
+		dir = "." + string(filepath.Separator) + dir // so "go vet {dir}" names a package path
+	}
+	return strings.NewReplacer("{file}", shellQuote(path), "{dir}", shellQuote(dir)).Replace(command)
+}
+
+// run executes command for the hook and returns its combined output.
+func (h PostWriteHook) run(command string) (string, error) {
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = defaultPostWriteTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	output, err := shellCommand(ctx, command).CombinedOutput()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("timed out after %s", timeout)
+	}
+	return strings.TrimSpace(string(output)), err
+}
+
+// runPostWriteHooks formats and lints the files a tool just wrote. It
+// returns notes for the model: which files the formatters changed, and any
+// formatter or linter failures with their output. Files that no longer
+// exist, such as the source of a rename, are skipped.
+func runPostWriteHooks(hooks []PostWriteHook, paths []string) string {
+	var notes []string
+	for _, path := range paths {
+		if info, err := os.Stat(path); err != nil || info.IsDir() {
+			continue
+		}
+		for _, hook := range hooks {
+			if !hook.matches(path) {
+				continue
+			}
+			if hook.Format != "" {
+				before, _ := os.ReadFile(path)
+				command := hook.expand(hook.Format, path)
+				output, err := hook.run(command)
+				if err != nil {
+					notes = append(notes, fmt.Sprintf("Formatter `%s` failed (%v):\n%s", command, err, truncateToolResult(output, maxPostWriteOutput)))
+				} else if after, _ := os.ReadFile(path); !bytes.Equal(before, after) {
+					notes = append(notes, fmt.Sprintf("%s was reformatted by `%s`; read it again before editing the changed lines.", path, command))
+				}
+			}
+			if hook.Lint != "" {
+				command := hook.expand(hook.Lint, path)
+				output, err := hook.run(command)
+				if err != nil {
+					notes = append(notes, fmt.Sprintf("`%s` reported problems (%v):\n%s", command, err, truncateToolResult(output, maxPostWriteOutput)))
+				}
+			}
+		}
+	}
+	return strings.Join(notes, "\n\n")
+}
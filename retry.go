@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// InferenceErrorKind classifies a failed chat completion request so the
+// agent can decide whether to retry, fall back to another model or give up.
+type InferenceErrorKind int
+
+const (
+	InferenceErrorUnknown InferenceErrorKind = iota
+	InferenceErrorRateLimit
+	InferenceErrorServer
+	InferenceErrorNetwork
+	InferenceErrorContextLength
+	InferenceErrorAuth
+	InferenceErrorBadRequest
+)
+
+func (k InferenceErrorKind) String() string {
+	switch k {
+	case InferenceErrorRateLimit:
+		return "rate limit"
+	case InferenceErrorServer:
+		return "server error"
+	case InferenceErrorNetwork:
+		return "network error"
+	case InferenceErrorContextLength:
+		return "context length exceeded"
+	case InferenceErrorAuth:
+		return "authentication error"
+	case InferenceErrorBadRequest:
+		return "bad request"
+	default:
+		return "unknown error"
+	}
+}
+
+// Retryable reports whether a request that failed with this kind of error
+// may succeed if sent again unchanged.
+func (k InferenceErrorKind) Retryable() bool {
+	switch k {
+	case InferenceErrorRateLimit, InferenceErrorServer, InferenceErrorNetwork:
+		return true
+	default:
+		return false
+	}
+}
+
+// InferenceError wraps an error returned by the provider together with its
+// classification and any Retry-After hint sent by the server.
+type InferenceError struct {
+	Kind       InferenceErrorKind
+	Model      string
+	Attempts   int
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *InferenceError) Error() string {
+	return fmt.Sprintf("%s (model %s, %d attempt(s)): %s", e.Kind, e.Model, e.Attempts, e.Err)
+}
+
+func (e *InferenceError) Unwrap() error {
+	return e.Err
+}
+
+func classifyInferenceError(err error) InferenceErrorKind {
+	if err == nil {
+		return InferenceErrorUnknown
+	}
+
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		if isContextLengthError(apiErr.Code, apiErr.Message) {
+			return InferenceErrorContextLength
+		}
+		return classifyHTTPStatus(apiErr.HTTPStatusCode)
+	}
+
+	var reqErr *openai.RequestError
+	if errors.As(err, &reqErr) {
+		if isContextLengthError(nil, string(reqErr.Body)) {
+			return InferenceErrorContextLength
+		}
+		if reqErr.HTTPStatusCode != 0 {
+			return classifyHTTPStatus(reqErr.HTTPStatusCode)
+		}
+	}
+
+	if errors.Is(err, context.Canceled) {
+		return InferenceErrorUnknown
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return InferenceErrorNetwork
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return InferenceErrorNetwork
+	}
+
+	return InferenceErrorUnknown
+}
+
+func classifyHTTPStatus(status int) InferenceErrorKind {
+	switch {
+	case status == http.StatusTooManyRequests:
+		return InferenceErrorRateLimit
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return InferenceErrorAuth
+	case status >= 500:
+		return InferenceErrorServer
+	case status == http.StatusRequestEntityTooLarge:
+		return InferenceErrorContextLength
+	case status >= 400:
+		return InferenceErrorBadRequest
+	default:
+		return InferenceErrorUnknown
+	}
+}
+
+func isContextLengthError(code any, message string) bool {
+	if s, ok := code.(string); ok && s == "context_length_exceeded" {
+		return true
+	}
+	message = strings.ToLower(message)
+	return strings.Contains(message, "context_length_exceeded") ||
+		strings.Contains(message, "context length") ||
+		strings.Contains(message, "maximum context") ||
+		strings.Contains(message, "reduce the length of the messages")
+}
+
+// RetryPolicy controls how failed inference calls are retried.
+type RetryPolicy struct {
+	MaxAttempts   int           // attempts per model, including the first
+	BaseDelay     time.Duration // delay before the first retry
+	MaxDelay      time.Duration // upper bound for any single delay
+	FallbackModel string        // model to switch to once MaxAttempts is exhausted
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	BaseDelay:   1 * time.Second,
+	MaxDelay:    60 * time.Second,
+}
+
+// backoff returns the delay before retry number attempt (starting at 1),
+// preferring the server's Retry-After hint when it is present.
+func (p RetryPolicy) backoff(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return min(retryAfter, p.MaxDelay)
+	}
+
+	delay := p.BaseDelay << (attempt - 1)
+	if delay <= 0 || delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	// Add up to 20% jitter so parallel clients don't retry in lockstep
+	jitter := time.Duration(rand.Int64N(int64(delay)/5 + 1))
+	return min(delay+jitter, p.MaxDelay)
+}
+
+type retryAfterKey struct{}
+
+// retryAfterTransport records the Retry-After header of failed responses
+// into a slot carried by the request context. go-openai does not expose
+// response headers on its errors, so this is how the hint reaches the agent.
+type retryAfterTransport struct {
+	base http.RoundTripper
+}
+
+func (t retryAfterTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	if slot, ok := req.Context().Value(retryAfterKey{}).(*time.Duration); ok && resp.StatusCode >= 400 {
+		*slot = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	}
+	return resp, nil
+}
+
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+		return time.Duration(seconds * float64(time.Second))
+	}
+	if when, err := http.ParseTime(value); err == nil {
+		if d := when.Sub(now); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// createChatCompletionWithRetry sends request, retrying transient failures
+// with exponential backoff. When every attempt against request.Model fails
+// and the policy names a fallback model, the same request is retried there.
+func (a *Agent) createChatCompletionWithRetry(ctx context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	models := []string{request.Model}
+	if fallback := a.retry.FallbackModel; fallback != "" && fallback != request.Model {
+		models = append(models, fallback)
+	}
+
+	maxAttempts := max(a.retry.MaxAttempts, 1)
+
+	var lastErr *InferenceError
+	for i, model := range models {
+		if i > 0 {
+			a.emit(ErrorEvent{
+				Message:  fmt.Sprintf("%s; falling back to model %s", lastErr.Kind, model),
+				Retrying: true,
+			})
+		}
+		request.Model = model
+
+		for attempt := 1; attempt <= maxAttempts; attempt++ {
+			var retryAfter time.Duration
+			attemptCtx := context.WithValue(ctx, retryAfterKey{}, &retryAfter)
+
+			var response openai.ChatCompletionResponse
+			var err error
+			if a.stream {
+				response, err = a.streamChatCompletion(attemptCtx, request)
+			} else {
+				response, err = a.client.CreateChatCompletion(attemptCtx, request)
+			}
+			if err == nil {
+				a.emit(UsageEvent{
+					Model:            model,
+					PromptTokens:     response.Usage.PromptTokens,
+					CompletionTokens: response.Usage.CompletionTokens,
+					TotalTokens:      response.Usage.TotalTokens,
+				})
+				return response, nil
+			}
+
+			kind := classifyInferenceError(err)
+			lastErr = &InferenceError{
+				Kind:       kind,
+				Model:      model,
+				Attempts:   attempt,
+				RetryAfter: retryAfter,
+				Err:        err,
+			}
+			if ctx.Err() != nil {
+				return openai.ChatCompletionResponse{}, lastErr
+			}
+			if !kind.Retryable() {
+				break
+			}
+			if attempt == maxAttempts {
+				break
+			}
+
+			delay := a.retry.backoff(attempt, retryAfter)
+			a.emit(ErrorEvent{
+				Message: fmt.Sprintf("%s from %s, retrying in %s (attempt %d/%d)",
+					kind, model, delay.Round(100*time.Millisecond), attempt+1, maxAttempts),
+				Retrying: true,
+			})
+			if err := sleepContext(ctx, delay); err != nil {
+				return openai.ChatCompletionResponse{}, lastErr
+			}
+		}
+
+		// Auth and malformed requests will fail the same way on any model
+		if lastErr.Kind == InferenceErrorAuth || lastErr.Kind == InferenceErrorBadRequest {
+			break
+		}
+	}
+
+	return openai.ChatCompletionResponse{}, lastErr
+}
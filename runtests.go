@@ -0,0 +1,665 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var RunTestsDefinition = ToolDefinition{
+	Name: "run_tests",
+	Description: `Run the project's tests and get a structured summary: pass, fail and skip counts, and for each failing test its name, file:line location and trimmed output.
+Supports go test, pytest and jest; the framework is detected from the project files. Prefer this over terminal_run for running tests.`,
+	InputSchema: GenerateSchema[RunTestsInput](),
+	Function:    RunTests,
+}
+
+type RunTestsInput struct {
+	Path      string `json:"path,omitempty" jsonschema_description:"Directory or test file to run. Defaults to the whole project. A Go directory includes its subpackages; a Go file runs its package."`
+	Filter    string `json:"filter,omitempty" jsonschema_description:"Only run tests matching this name pattern (go test -run, pytest -k, jest -t)"`
+	Framework string `json:"framework,omitempty" jsonschema:"enum=go,enum=pytest,enum=jest" jsonschema_description:"Test framework to use. Detected when omitted."`
+	Timeout   int    `json:"timeout,omitempty" jsonschema_description:"Timeout in seconds (default 300)"`
+}
+
+const (
+	defaultTestTimeout = 5 * time.Minute
+	maxTestFailures    = 20
+	maxFailureLines    = 30
+)
+
+// testReport is the outcome of a test run, whatever the framework.
+type testReport struct {
+	Framework string
+	Command   string
+	Passed    int
+	Failed    int
+	Skipped   int
+	Failures  []testFailure
+	Duration  time.Duration
+}
+
+type testFailure struct {
+	Name     string
+	Location string // file:line, when known
+	Output   string
+}
+
+func RunTests(input json.RawMessage) (string, error) {
+	testsInput := RunTestsInput{}
+	if err := json.Unmarshal(input, &testsInput); err != nil {
+		return "", err
+	}
+	if testsInput.Path != "" {
+		if _, err := os.Stat(testsInput.Path); err != nil {
+			return "", err
+		}
+	}
+
+	framework := testsInput.Framework
+	if framework == "" {
+		framework = detectTestFramework(testsInput.Path)
+		if framework == "" {
+			return "", fmt.Errorf("could not detect the test framework (looked for go.mod, package.json with jest, and pytest configuration); pass framework explicitly, or use terminal_run")
+		}
+	}
+
+	timeout := defaultTestTimeout
+	if testsInput.Timeout > 0 {
+		timeout = time.Duration(testsInput.Timeout) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	var report *testReport
+	var err error
+	switch framework {
+	case "go":
+		report, err = runGoTests(ctx, testsInput)
+	case "pytest":
+		report, err = runPytest(ctx, testsInput)
+	case "jest":
+		report, err = runJest(ctx, testsInput)
+	default:
+		return "", fmt.Errorf("unsupported framework %q (want go, pytest or jest)", framework)
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return "", fmt.Errorf("tests timed out after %s; run a smaller subset with path or filter, or raise timeout", timeout)
+	}
+	if err != nil {
+		return "", err
+	}
+	report.Framework = framework
+	report.Duration = time.Since(start)
+	return report.String(), nil
+}
+
+var jestTestFile = regexp.MustCompile(`\.(test|spec)\.[cm]?[jt]sx?$`)
+
+// detectTestFramework guesses the framework from the test file's name or
+// from the project files in path and its parent directories.
+func detectTestFramework(path string) string {
+	switch name := filepath.Base(path); {
+	case strings.HasSuffix(name, "_test.go"):
+		return "go"
+	case strings.HasSuffix(name, ".py"):
+		return "pytest"
+	case jestTestFile.MatchString(name):
+		return "jest"
+	}
+
+	dir := path
+	if dir == "" {
+		dir = "."
+	}
+	if info, err := os.Stat(dir); err == nil && !info.IsDir() {
+		dir = filepath.Dir(dir)
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	for {
+		if fileExists(filepath.Join(dir, "go.mod")) {
+			return "go"
+		}
+		if data, err := os.ReadFile(filepath.Join(dir, "package.json")); err == nil && bytes.Contains(data, []byte(`"jest"`)) {
+			return "jest"
+		}
+		for _, name := range []string{"pytest.ini", "conftest.py", "pyproject.toml", "setup.cfg", "tox.ini"} {
+			if fileExists(filepath.Join(dir, name)) {
+				return "pytest"
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func (r *testReport) String() string {
+	var b strings.Builder
+	status := "PASSED"
+	if r.Failed > 0 {
+		status = "FAILED"
+	}
+	fmt.Fprintf(&b, "%s: %d passed, %d failed, %d skipped (%s, %.1fs)\n", status, r.Passed, r.Failed, r.Skipped, r.Framework, r.Duration.Seconds())
+	fmt.Fprintf(&b, "Command: %s\n", r.Command)
+	for i, failure := range r.Failures {
+		if i == maxTestFailures {
+			fmt.Fprintf(&b, "\n[... %d more failures]\n", len(r.Failures)-i)
+			break
+		}
+		fmt.Fprintf(&b, "\nFAIL %s", failure.Name)
+		if failure.Location != "" {
+			fmt.Fprintf(&b, " at %s", failure.Location)
+		}
+		b.WriteString("\n")
+		for _, line := range strings.Split(failure.Output, "\n") {
+			b.WriteString("    " + line + "\n")
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;]*[A-Za-z]`)
+
+// trimFailureOutput strips colors and blank edges from failure output and
+// keeps at most maxFailureLines lines.
+func trimFailureOutput(output string) string {
+	output = strings.TrimSpace(ansiEscape.ReplaceAllString(output, ""))
+	lines := strings.Split(output, "\n")
+	if len(lines) > maxFailureLines {
+		lines = append(lines[:maxFailureLines], fmt.Sprintf("[... %d more lines]", len(lines)-maxFailureLines))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// relativePath shortens an absolute path to one relative to the working
+// directory when it lies inside it.
+func relativePath(path string) string {
+	if !filepath.IsAbs(path) {
+		return path
+	}
+	if wd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(wd, path); err == nil && !strings.HasPrefix(rel, "..") {
+			return rel
+		}
+	}
+	return path
+}
+
+// runTestCommand runs a test command, whose non-zero exit status just means
+// that tests failed, and returns its combined output.
+func runTestCommand(ctx context.Context, name string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	output, err := cmd.CombinedOutput()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return string(output), fmt.Errorf("failed to run %s: %w", name, err)
+	}
+	return string(output), nil
+}
+
+// goTestEvent is one line of go test -json output.
+type goTestEvent struct {
+	Action      string
+	Package     string
+	ImportPath  string // build-output and build-fail events
+	Test        string
+	Output      string
+	FailedBuild string
+}
+
+var goFileLine = regexp.MustCompile(`([\w./\\-]+\.go):(\d+)`)
+
+func runGoTests(ctx context.Context, in RunTestsInput) (*testReport, error) {
+	pattern := "./..."
+	if in.Path != "" {
+		// A file runs just its package; a directory includes subpackages
+		dir, suffix := in.Path, "/..."
+		if info, err := os.Stat(dir); err == nil && !info.IsDir() {
+			dir, suffix = filepath.Dir(dir), ""
+		}
+		dir = filepath.ToSlash(filepath.Clean(dir))
+		if !filepath.IsAbs(dir) && dir != "." && !strings.HasPrefix(dir, "../") {
+			dir = "./" + dir
+		}
+		pattern = dir + suffix
+	}
+	args := []string{"test", "-json"}
+	if in.Filter != "" {
+		args = append(args, "-run", in.Filter)
+	}
+	args = append(args, pattern)
+	report := &testReport{Command: "go " + strings.Join(args, " ")}
+
+	output, err := runTestCommand(ctx, "go", args...)
+	if err != nil {
+		return nil, err
+	}
+
+	type testKey struct{ pkg, test string }
+	testOutput := map[testKey]*strings.Builder{}
+	buildOutput := map[string]*strings.Builder{}
+	var failedTests []testKey
+	var failedPackages []goTestEvent
+	events := 0
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+	var stray strings.Builder
+	for scanner.Scan() {
+		var event goTestEvent
+		if json.Unmarshal(scanner.Bytes(), &event) != nil || event.Action == "" {
+			stray.WriteString(scanner.Text() + "\n")
+			continue
+		}
+		events++
+		switch event.Action {
+		case "build-output":
+			if buildOutput[event.ImportPath] == nil {
+				buildOutput[event.ImportPath] = &strings.Builder{}
+			}
+			buildOutput[event.ImportPath].WriteString(event.Output)
+		case "output":
+			key := testKey{event.Package, event.Test}
+			if testOutput[key] == nil {
+				testOutput[key] = &strings.Builder{}
+			}
+			testOutput[key].WriteString(event.Output)
+		case "pass":
+			if event.Test != "" {
+				report.Passed++
+			}
+		case "skip":
+			if event.Test != "" {
+				report.Skipped++
+			}
+		case "fail":
+			if event.Test != "" {
+				report.Failed++
+				failedTests = append(failedTests, testKey{event.Package, event.Test})
+			} else {
+				failedPackages = append(failedPackages, event)
+			}
+		}
+	}
+	if events == 0 {
+		return nil, fmt.Errorf("go test produced no results:\n%s", trimFailureOutput(output))
+	}
+
+	var packages []string
+	for _, event := range failedPackages {
+		packages = append(packages, event.Package)
+	}
+	dirs := goPackageDirs(ctx, packages)
+
+	// A failing subtest also fails its parents; report only the subtest
+	failedParents := map[testKey]bool{}
+	for _, key := range failedTests {
+		for name := key.test; strings.Contains(name, "/"); {
+			name = name[:strings.LastIndex(name, "/")]
+			failedParents[testKey{key.pkg, name}] = true
+		}
+	}
+	report.Failed -= len(failedParents)
+	packagesWithFailedTests := map[string]bool{}
+	for _, key := range failedTests {
+		packagesWithFailedTests[key.pkg] = true
+		if failedParents[key] {
+			continue
+		}
+		text := ""
+		if b := testOutput[key]; b != nil {
+			text = cleanGoTestOutput(b.String())
+		}
+		report.Failures = append(report.Failures, testFailure{
+			Name:     key.test + " (" + key.pkg + ")",
+			Location: goLocation(text, dirs[key.pkg]),
+			Output:   trimFailureOutput(text),
+		})
+	}
+
+	// Packages that failed without a failing test didn't build, or crashed
+	// outside any test
+	for _, event := range failedPackages {
+		pkg := event.Package
+		if packagesWithFailedTests[pkg] {
+			continue
+		}
+		var text, location string
+		if b := buildOutput[event.FailedBuild]; event.FailedBuild != "" && b != nil {
+			// Compiler errors name files relative to the working directory
+			text = b.String()
+			location = goLocation(text, "")
+		} else if b := testOutput[testKey{pkg, ""}]; b != nil {
+			text = cleanGoTestOutput(b.String())
+			location = goLocation(text, dirs[pkg])
+		}
+		if strings.TrimSpace(text) == "" {
+			text = stray.String()
+		}
+		report.Failed++
+		report.Failures = append(report.Failures, testFailure{
+			Name:     pkg + " [package failed]",
+			Location: location,
+			Output:   trimFailureOutput(text),
+		})
+	}
+	return report, nil
+}
+
+// goLocation returns the first file:line in output outside the Go
+// installation, such as a t.Error call or the test's frame in a panic.
+// Relative names are resolved against the package directory dir.
+func goLocation(output, dir string) string {
+	goroot := filepath.Clean(runtime.GOROOT())
+	for _, match := range goFileLine.FindAllStringSubmatch(output, -1) {
+		file := match[1]
+		if !filepath.IsAbs(file) && dir != "" {
+			file = filepath.Join(dir, file)
+		}
+		if strings.HasPrefix(file, goroot+string(filepath.Separator)) {
+			continue
+		}
+		return relativePath(file) + ":" + match[2]
+	}
+	return ""
+}
+
+// cleanGoTestOutput drops go test's progress lines, leaving what the test
+// itself printed.
+func cleanGoTestOutput(output string) string {
+	var kept []string
+	for _, line := range strings.Split(output, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "=== "),
+			strings.HasPrefix(trimmed, "--- FAIL"),
+			strings.HasPrefix(trimmed, "--- PASS"),
+			strings.HasPrefix(trimmed, "--- SKIP"),
+			trimmed == "FAIL", trimmed == "PASS",
+			strings.HasPrefix(trimmed, "FAIL\t"),
+			strings.HasPrefix(trimmed, "ok  \t"):
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return strings.Join(kept, "\n")
+}
+
+// goPackageDirs maps import paths to their directories, so that file names
+// in test output can be turned into paths.
+func goPackageDirs(ctx context.Context, packages []string) map[string]string {
+	dirs := map[string]string{}
+	if len(packages) == 0 {
+		return dirs
+	}
+	args := append([]string{"list", "-e", "-f", "{{.ImportPath}}\t{{.Dir}}"}, packages...)
+	output, err := exec.CommandContext(ctx, "go", args...).Output()
+	if err != nil {
+		return dirs
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		if pkg, dir, ok := strings.Cut(line, "\t"); ok {
+			dirs[pkg] = dir
+		}
+	}
+	return dirs
+}
+
+// junitReport is the subset of pytest's JUnit XML that is needed.
+type junitReport struct {
+	Cases []junitCase `xml:"testsuite>testcase"`
+}
+
+type junitCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	File      string        `xml:"file,attr"`
+	Line      string        `xml:"line,attr"`
+	Failure   *junitProblem `xml:"failure"`
+	Error     *junitProblem `xml:"error"`
+	Skipped   *junitProblem `xml:"skipped"`
+}
+
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+var pythonFileLine = regexp.MustCompile(`([\w./\\-]+\.py):(\d+)`)
+
+func runPytest(ctx context.Context, in RunTestsInput) (*testReport, error) {
+	xmlFile, err := os.CreateTemp("", "pytest-*.xml")
+	if err != nil {
+		return nil, err
+	}
+	xmlFile.Close()
+	defer os.Remove(xmlFile.Name())
+
+	python := "python3"
+	if _, err := exec.LookPath(python); err != nil {
+		python = "python"
+	}
+	args := []string{"-m", "pytest", "-q", "--tb=short", "-o", "junit_family=xunit1", "--junitxml=" + xmlFile.Name()}
+	if in.Filter != "" {
+		args = append(args, "-k", in.Filter)
+	}
+	if in.Path != "" {
+		args = append(args, in.Path)
+	}
+	report := &testReport{Command: python + " " + strings.Join(args, " ")}
+
+	output, err := runTestCommand(ctx, python, args...)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(xmlFile.Name())
+	if err != nil || len(bytes.TrimSpace(data)) == 0 {
+		return nil, fmt.Errorf("pytest produced no results:\n%s", trimFailureOutput(output))
+	}
+	if err := parsePytestReport(data, report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func parsePytestReport(data []byte, report *testReport) error {
+	var junit junitReport
+	if err := xml.Unmarshal(data, &junit); err != nil {
+		return fmt.Errorf("failed to parse pytest results: %w", err)
+	}
+	if len(junit.Cases) == 0 {
+		// Older pytest versions write a single suite as the document element
+		var suite struct {
+			Cases []junitCase `xml:"testcase"`
+		}
+		if xml.Unmarshal(data, &suite) == nil {
+			junit.Cases = suite.Cases
+		}
+	}
+
+	for _, tc := range junit.Cases {
+		problem := tc.Failure
+		if problem == nil {
+			problem = tc.Error
+		}
+		switch {
+		case problem != nil:
+			report.Failed++
+			name := tc.Name
+			if tc.ClassName != "" {
+				name = tc.ClassName + "::" + tc.Name
+			}
+			report.Failures = append(report.Failures, testFailure{
+				Name:     name,
+				Location: pytestLocation(tc, problem.Text),
+				Output:   trimFailureOutput(firstNonEmpty(problem.Text, problem.Message)),
+			})
+		case tc.Skipped != nil:
+			report.Skipped++
+		default:
+			report.Passed++
+		}
+	}
+	return nil
+}
+
+// pytestLocation prefers the deepest traceback line in the test's own file,
+// falling back to where the test is defined.
+func pytestLocation(tc junitCase, traceback string) string {
+	location := ""
+	for _, match := range pythonFileLine.FindAllStringSubmatch(traceback, -1) {
+		if tc.File == "" || filepath.Clean(match[1]) == filepath.Clean(tc.File) {
+			location = relativePath(match[1]) + ":" + match[2]
+		}
+	}
+	if location == "" && tc.File != "" {
+		location = relativePath(tc.File)
+		if line, err := strconv.Atoi(tc.Line); err == nil {
+			location += ":" + strconv.Itoa(line+1) // pytest counts lines from 0
+		}
+	}
+	return location
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// jestReport is the subset of jest --json output that is needed.
+type jestReport struct {
+	NumPassedTests  int `json:"numPassedTests"`
+	NumFailedTests  int `json:"numFailedTests"`
+	NumPendingTests int `json:"numPendingTests"`
+	NumTodoTests    int `json:"numTodoTests"`
+	TestResults     []struct {
+		Name             string `json:"name"`
+		Status           string `json:"status"`
+		Message          string `json:"message"`
+		AssertionResults []struct {
+			FullName        string            `json:"fullName"`
+			Status          string            `json:"status"`
+			FailureMessages []string          `json:"failureMessages"`
+			Location        *jestTestLocation `json:"location"`
+		} `json:"assertionResults"`
+	} `json:"testResults"`
+}
+
+type jestTestLocation struct {
+	Line int `json:"line"`
+}
+
+var jsFileLine = regexp.MustCompile(`\(?([^\s()]+\.[cm]?[jt]sx?):(\d+):\d+\)?`)
+
+func runJest(ctx context.Context, in RunTestsInput) (*testReport, error) {
+	jsonFile, err := os.CreateTemp("", "jest-*.json")
+	if err != nil {
+		return nil, err
+	}
+	jsonFile.Close()
+	defer os.Remove(jsonFile.Name())
+
+	args := []string{"jest", "--ci", "--json", "--testLocationInResults", "--outputFile=" + jsonFile.Name()}
+	if in.Filter != "" {
+		args = append(args, "-t", in.Filter)
+	}
+	if in.Path != "" {
+		args = append(args, in.Path)
+	}
+	report := &testReport{Command: "npx " + strings.Join(args, " ")}
+
+	output, err := runTestCommand(ctx, "npx", args...)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(jsonFile.Name())
+	if err != nil || len(bytes.TrimSpace(data)) == 0 {
+		return nil, fmt.Errorf("jest produced no results:\n%s", trimFailureOutput(output))
+	}
+	if err := parseJestReport(data, report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func parseJestReport(data []byte, report *testReport) error {
+	var jest jestReport
+	if err := json.Unmarshal(data, &jest); err != nil {
+		return fmt.Errorf("failed to parse jest results: %w", err)
+	}
+	report.Passed = jest.NumPassedTests
+	report.Failed = jest.NumFailedTests
+	report.Skipped = jest.NumPendingTests + jest.NumTodoTests
+
+	sort.SliceStable(jest.TestResults, func(i, j int) bool { return jest.TestResults[i].Name < jest.TestResults[j].Name })
+	for _, file := range jest.TestResults {
+		path := relativePath(file.Name)
+		failedAssertions := 0
+		for _, assertion := range file.AssertionResults {
+			if assertion.Status != "failed" {
+				continue
+			}
+			failedAssertions++
+			message := strings.Join(assertion.FailureMessages, "\n")
+			report.Failures = append(report.Failures, testFailure{
+				Name:     assertion.FullName + " (" + path + ")",
+				Location: jestLocation(file.Name, message, assertion.Location),
+				Output:   trimFailureOutput(message),
+			})
+		}
+		// A suite that fails without failing tests didn't load, e.g. a
+		// syntax error
+		if file.Status == "failed" && failedAssertions == 0 {
+			report.Failed++
+			report.Failures = append(report.Failures, testFailure{
+				Name:     path + " [suite failed]",
+				Location: jestLocation(file.Name, file.Message, nil),
+				Output:   trimFailureOutput(file.Message),
+			})
+		}
+	}
+	return nil
+}
+
+// jestLocation prefers the first stack frame in the test file, falling
+// back to where the test is declared.
+func jestLocation(testFile, message string, declared *jestTestLocation) string {
+	message = ansiEscape.ReplaceAllString(message, "")
+	for _, match := range jsFileLine.FindAllStringSubmatch(message, -1) {
+		if filepath.Clean(match[1]) == filepath.Clean(testFile) {
+			return relativePath(match[1]) + ":" + match[2]
+		}
+	}
+	if declared != nil && declared.Line > 0 {
+		return relativePath(testFile) + ":" + strconv.Itoa(declared.Line)
+	}
+	return ""
+}
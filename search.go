@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// SearchConfig selects the backend of the web_search tool. Without a
+// provider the tool is not offered.
+type SearchConfig struct {
+	// Provider is searxng, for a SearxNG instance with the JSON format
+	// enabled, or http, for any endpoint answering GET requests with JSON.
+	Provider string            `yaml:"provider,omitempty"`
+	URL      string            `yaml:"url,omitempty"`
+	Headers  map[string]string `yaml:"headers,omitempty"`
+	// MaxResults is how many results are returned when the model asks for
+	// no particular number.
+	MaxResults int `yaml:"max_results,omitempty"`
+
+	// How the http provider is called and read: the query parameter, the
+	// dotted path to the array of results in the response, and the fields
+	// of each result.
+	QueryParam   string `yaml:"query_param,omitempty"`
+	ResultsPath  string `yaml:"results_path,omitempty"`
+	TitleField   string `yaml:"title_field,omitempty"`
+	URLField     string `yaml:"url_field,omitempty"`
+	SnippetField string `yaml:"snippet_field,omitempty"`
+}
+
+func (c SearchConfig) Validate() error {
+	if c.Provider == "" {
+		return nil
+	}
+	var errs []error
+	if c.Provider != "searxng" && c.Provider != "http" {
+		errs = append(errs, fmt.Errorf("invalid web.search.provider %q (want searxng or http)", c.Provider))
+	}
+	if u, err := url.Parse(c.URL); c.URL == "" || err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		errs = append(errs, fmt.Errorf("web.search.url %q must be an http or https URL", c.URL))
+	}
+	if c.MaxResults < 0 {
+		errs = append(errs, errors.New("web.search.max_results cannot be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+const (
+	defaultSearchResults = 8
+	maxSearchResults     = 20
+	maxSearchSnippet     = 300
+	maxSearchBytes       = 2 << 20
+)
+
+// SearchProvider runs a web search and returns at most limit results.
+type SearchProvider interface {
+	Search(query string, limit int) ([]SearchResult, error)
+}
+
+type SearchResult struct {
+	Title   string
+	URL     string
+	Snippet string
+}
+
+// newSearchProvider returns the configured provider, or nil when search is
+// disabled.
+func newSearchProvider(cfg SearchConfig, timeout time.Duration) SearchProvider {
+	client := &http.Client{Timeout: timeout}
+	headers := expandEnvMap(cfg.Headers)
+	switch cfg.Provider {
+	case "searxng":
+		endpoint := strings.TrimSuffix(cfg.URL, "/")
+		if !strings.HasSuffix(endpoint, "/search") {
+			endpoint += "/search"
+		}
+		return &httpSearch{
+			client:   client,
+			endpoint: endpoint,
+			headers:  headers,
+			params:   url.Values{"format": {"json"}},
+			query:    "q",
+			results:  []string{"results"},
+			title:    "title",
+			url:      "url",
+			snippet:  "content",
+			name:     "SearxNG",
+			refused:  "enable the json format under search.formats in its settings.yml",
+		}
+	case "http":
+		return &httpSearch{
+			client:   client,
+			endpoint: cfg.URL,
+			headers:  headers,
+			query:    firstNonEmpty(cfg.QueryParam, "q"),
+			results:  strings.Split(firstNonEmpty(cfg.ResultsPath, "results"), "."),
+			title:    firstNonEmpty(cfg.TitleField, "title"),
+			url:      firstNonEmpty(cfg.URLField, "url"),
+			snippet:  firstNonEmpty(cfg.SnippetField, "snippet"),
+			name:     "search endpoint",
+		}
+	}
+	return nil
+}
+
+// httpSearch queries a search endpoint with a GET request and reads the
+// results out of its JSON response. SearxNG is one configuration of it.
+type httpSearch struct {
+	client   *http.Client
+	endpoint string
+	headers  map[string]string
+	params   url.Values // sent with every query
+	query    string     // parameter carrying the query
+	results  []string   // path to the results array
+	title    string
+	url      string
+	snippet  string
+	name     string // for error messages
+	refused  string // hint added when the endpoint answers 403
+}
+
+func (s *httpSearch) Search(query string, limit int) ([]SearchResult, error) {
+	u, err := url.Parse(s.endpoint)
+	if err != nil {
+		return nil, err
+	}
+	params := u.Query()
+	for k, v := range s.params {
+		params[k] = v
+	}
+	params.Set(s.query, query)
+	u.RawQuery = params.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", webUserAgent)
+	for k, v := range s.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.name, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxSearchBytes))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.name, err)
+	}
+	if resp.StatusCode >= 400 {
+		if s.refused != "" && resp.StatusCode == http.StatusForbidden {
+			return nil, fmt.Errorf("%s refused the query (%s); %s", s.name, resp.Status, s.refused)
+		}
+		return nil, fmt.Errorf("%s returned %s: %s", s.name, resp.Status, strings.TrimSpace(string(body[:min(len(body), 200)])))
+	}
+
+	var response any
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("%s did not return JSON: %w", s.name, err)
+	}
+	for _, key := range s.results {
+		object, _ := response.(map[string]any)
+		response = object[key]
+	}
+	items, ok := response.([]any)
+	if !ok {
+		return nil, fmt.Errorf("%s response has no results array at %q", s.name, strings.Join(s.results, "."))
+	}
+
+	var results []SearchResult
+	for _, item := range items {
+		fields, _ := item.(map[string]any)
+		result := SearchResult{
+			Title:   stringField(fields, s.title),
+			URL:     stringField(fields, s.url),
+			Snippet: stringField(fields, s.snippet),
+		}
+		if result.URL == "" {
+			continue
+		}
+		results = append(results, result)
+		if len(results) == limit {
+			break
+		}
+	}
+	return results, nil
+}
+
+func stringField(fields map[string]any, name string) string {
+	value, _ := fields[name].(string)
+	return value
+}
+
+type WebSearchInput struct {
+	Query      string `json:"query" jsonschema:"required" jsonschema_description:"The search query, such as an error message or a library name and what you want to do with it"`
+	MaxResults int    `json:"max_results,omitempty" jsonschema_description:"How many results to return (default 8, at most 20)"`
+}
+
+func (w *WebClient) webSearch(input json.RawMessage) (string, error) {
+	searchInput := WebSearchInput{}
+	if err := json.Unmarshal(input, &searchInput); err != nil {
+		return "", err
+	}
+	query := strings.TrimSpace(searchInput.Query)
+	if query == "" {
+		return "", errors.New("query cannot be empty")
+	}
+	limit := searchInput.MaxResults
+	if limit <= 0 {
+		limit = w.searchResults
+	}
+	limit = min(limit, maxSearchResults)
+
+	// Ask for more than needed, since results on domains the agent may not
+	// fetch are dropped.
+	results, err := w.search.Search(query, min(limit*2, 2*maxSearchResults))
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	n, dropped := 0, 0
+	for _, result := range results {
+		if n == limit {
+			break
+		}
+		if u, err := url.Parse(result.URL); err != nil || w.checkURL(u) != nil {
+			dropped++
+			continue
+		}
+		n++
+		fmt.Fprintf(&b, "%d. %s\n   %s\n", n, firstNonEmpty(collapseSpace(result.Title), "(untitled)"), result.URL)
+		if snippet := collapseSpace(result.Snippet); snippet != "" {
+			if len(snippet) > maxSearchSnippet {
+				snippet = strings.ToValidUTF8(snippet[:maxSearchSnippet], "") + "…"
+			}
+			fmt.Fprintf(&b, "   %s\n", snippet)
+		}
+		b.WriteString("\n")
+	}
+	if n == 0 {
+		if dropped > 0 {
+			return fmt.Sprintf("No results for %q on the allowed domains (%s elsewhere left out).", query, pluralResults(dropped)), nil
+		}
+		return fmt.Sprintf("No results for %q.", query), nil
+	}
+	if dropped > 0 {
+		fmt.Fprintf(&b, "[%s on blocked or not allowed domains left out]\n", pluralResults(dropped))
+	}
+	b.WriteString("Use web_fetch to read a result.")
+	return b.String(), nil
+}
+
+func pluralResults(n int) string {
+	if n == 1 {
+		return "1 result"
+	}
+	return fmt.Sprintf("%d results", n)
+}
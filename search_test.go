@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func searchInput(t *testing.T, query string, maxResults int) json.RawMessage {
+	t.Helper()
+	input, err := json.Marshal(WebSearchInput{Query: query, MaxResults: maxResults})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return input
+}
+
+func TestWebSearchSearxNG(t *testing.T) {
+	var got url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/search" {
+			http.NotFound(w, r)
+			return
+		}
+		got = r.URL.Query()
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"query": "go generics", "results": [
+			{"title": "Generics tutorial", "url": "https://go.dev/doc/tutorial/generics", "content": "Get started   with generics."},
+			{"title": "No URL"},
+			{"title": "Spec", "url": "https://go.dev/ref/spec"}
+		]}`)
+	}))
+	defer server.Close()
+
+	web := NewWebClient(WebConfig{Search: SearchConfig{Provider: "searxng", URL: server.URL + "/"}}, 5*time.Second)
+	out, err := web.webSearch(searchInput(t, "go generics", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Get("format") != "json" || got.Get("q") != "go generics" {
+		t.Errorf("SearxNG was queried with %v, want format=json and q=go generics", got)
+	}
+	want := "1. Generics tutorial\n   https://go.dev/doc/tutorial/generics\n   Get started with generics.\n\n" +
+		"2. Spec\n   https://go.dev/ref/spec\n\n" +
+		"Use web_fetch to read a result."
+	if out != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out, want)
+	}
+}
+
+func TestWebSearchHTTPProvider(t *testing.T) {
+	var got *http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		fmt.Fprint(w, `{"web": {"results": [
+			{"name": "First", "link": "https://example.com/1", "description": "one"},
+			{"name": "Second", "link": "https://example.com/2", "description": "two"},
+			{"name": "Third", "link": "https://example.com/3", "description": "three"}
+		]}}`)
+	}))
+	defer server.Close()
+
+	t.Setenv("TEST_SEARCH_TOKEN", "secret")
+	web := NewWebClient(WebConfig{Search: SearchConfig{
+		Provider:     "http",
+		URL:          server.URL + "/api?country=us",
+		Headers:      map[string]string{"X-Token": "${TEST_SEARCH_TOKEN}"},
+		QueryParam:   "query",
+		ResultsPath:  "web.results",
+		TitleField:   "name",
+		URLField:     "link",
+		SnippetField: "description",
+	}}, 5*time.Second)
+	out, err := web.webSearch(searchInput(t, "widgets", 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if q := got.URL.Query(); q.Get("query") != "widgets" || q.Get("country") != "us" {
+		t.Errorf("endpoint was queried with %v, want query=widgets and country=us", q)
+	}
+	if token := got.Header.Get("X-Token"); token != "secret" {
+		t.Errorf("X-Token header is %q, want the expanded variable", token)
+	}
+	want := "1. First\n   https://example.com/1\n   one\n\n" +
+		"2. Second\n   https://example.com/2\n   two\n\n" +
+		"Use web_fetch to read a result."
+	if out != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out, want)
+	}
+
+	web = NewWebClient(WebConfig{Search: SearchConfig{Provider: "http", URL: server.URL, ResultsPath: "data.items"}}, 5*time.Second)
+	if _, err := web.webSearch(searchInput(t, "widgets", 0)); err == nil || !strings.Contains(err.Error(), `no results array at "data.items"`) {
+		t.Errorf("got error %v for a missing results path", err)
+	}
+}
+
+func TestWebSearchErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("q") {
+		case "forbidden":
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		case "broken":
+			http.Error(w, "upstream timed out", http.StatusBadGateway)
+		default:
+			fmt.Fprint(w, "<html>not json</html>")
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		provider string
+		query    string
+		wantErr  string
+	}{
+		{"searxng", "forbidden", "SearxNG refused the query (403 Forbidden); enable the json format under search.formats"},
+		{"http", "forbidden", "search endpoint returned 403 Forbidden: Forbidden"},
+		{"searxng", "broken", "SearxNG returned 502 Bad Gateway: upstream timed out"},
+		{"http", "html", "search endpoint did not return JSON"},
+	}
+	for _, tt := range tests {
+		web := NewWebClient(WebConfig{Search: SearchConfig{Provider: tt.provider, URL: server.URL}}, 5*time.Second)
+		_, err := web.webSearch(searchInput(t, tt.query, 0))
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s %q: got error %v, want one containing %q", tt.provider, tt.query, err, tt.wantErr)
+		}
+	}
+}
+
+func TestWebSearchDropsBlockedDomains(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("q") == "ads" {
+			fmt.Fprint(w, `{"results": [{"title": "Ad", "url": "https://ads.example.com/"}]}`)
+			return
+		}
+		fmt.Fprint(w, `{"results": [
+			{"title": "Tracker", "url": "https://ads.example.com/x"},
+			{"title": "Docs", "url": "https://docs.example.com/"},
+			{"title": "Elsewhere", "url": "https://other.org/"},
+			{"title": "Bad", "url": "ftp://docs.example.com/file"}
+		]}`)
+	}))
+	defer server.Close()
+
+	web := NewWebClient(WebConfig{
+		AllowedDomains: []string{"example.com"},
+		BlockedDomains: []string{"ads.example.com"},
+		Search:         SearchConfig{Provider: "http", URL: server.URL},
+	}, 5*time.Second)
+
+	out, err := web.webSearch(searchInput(t, "docs", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "1. Docs\n   https://docs.example.com/\n\n" +
+		"[3 results on blocked or not allowed domains left out]\n" +
+		"Use web_fetch to read a result."
+	if out != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out, want)
+	}
+
+	out, err = web.webSearch(searchInput(t, "ads", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `No results for "ads" on the allowed domains (1 result elsewhere left out).`; out != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+}
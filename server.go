@@ -0,0 +1,618 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// Session event types published on GET /sessions/{id}/events in addition
+// to the agent's own events.
+const (
+	EventTurnStarted      = "turn_started"
+	EventApprovalRequired = "approval_required"
+	EventApprovalResolved = "approval_resolved"
+	EventToolLoopPaused   = "tool_loop_paused"
+	EventTurnFinished     = "turn_finished"
+)
+
+type SessionEvent struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	Data any       `json:"data,omitempty"`
+}
+
+// Server drives agent sessions over HTTP/JSON, streaming their progress to
+// clients with server-sent events.
+type Server struct {
+	cfg    Config
+	client *openai.Client
+	tools  []ToolDefinition
+	lsp    *LSPManager
+	token  string
+	hosts  []string // host names besides localhost that requests may name
+	ctx    context.Context
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+// Session is one conversation with its own Agent. At most one turn runs at a
+// time; clients follow it through the event stream.
+type Session struct {
+	ID        string
+	CreatedAt time.Time
+	agent     *Agent
+
+	mu          sync.Mutex
+	busy        bool
+	turnCtx     context.Context
+	cancelTurn  context.CancelFunc
+	subscribers map[chan SessionEvent]struct{}
+	pending     map[string]*pendingApproval
+}
+
+type pendingApproval struct {
+	ID       string          `json:"id"`
+	Name     string          `json:"name"`
+	Input    json.RawMessage `json:"input,omitempty"`
+	Change   *FileChange     `json:"change,omitempty"`
+	Plan     string          `json:"plan,omitempty"`
+	decision chan bool
+}
+
+// runServe implements `agent serve [-addr host:port] [-token secret]`.
+// Without a token one is generated, since any web page the user visits can
+// reach a server on localhost.
+func runServe(cfg Config, args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	addr := flags.String("addr", "127.0.0.1:8080", "address to listen on")
+	token := flags.String("token", os.Getenv("AGENT_SERVER_TOKEN"), "bearer token clients must send (default $AGENT_SERVER_TOKEN)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *token == "" {
+		*token = newServerToken()
+		fmt.Printf("No -token or $AGENT_SERVER_TOKEN set; clients must send \"Authorization: Bearer %s\"\n", *token)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	terminalRunTimeout = cfg.Timeouts.TerminalRun
+	mcp, mcpTools, mcpErrs := ConnectMCPServers(ctx, cfg.MCPServers, cfg.Timeouts.MCP)
+	defer mcp.Close()
+	for _, err := range mcpErrs {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+	}
+	lsp := NewLSPManager(cfg.LSPServers, cfg.Timeouts.LSP)
+	defer lsp.Close()
+	web := NewWebClient(cfg.Web, cfg.Timeouts.WebFetch)
+
+	server := &Server{
+		cfg:      cfg,
+		client:   newClient(cfg),
+		tools:    cfg.FilterTools(slices.Concat(allTools, web.toolDefinitions(), lsp.toolDefinitions(), mcpTools)),
+		lsp:      lsp,
+		token:    *token,
+		ctx:      ctx,
+		sessions: map[string]*Session{},
+	}
+	if host, _, err := net.SplitHostPort(*addr); err == nil && host != "" {
+		if ip := net.ParseIP(host); ip == nil || !ip.IsUnspecified() {
+			server.hosts = []string{host}
+		}
+	}
+
+	// Request contexts derive from ctx so open event streams end on shutdown
+	httpServer := &http.Server{
+		Addr:        *addr,
+		Handler:     server.Handler(),
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		httpServer.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Printf("Serving agent API on http://%s\n", *addr)
+	if err := httpServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	server.endSessions("shutdown")
+	return nil
+}
+
+// endSessions runs the session_end hooks of every remaining session.
+func (s *Server) endSessions(reason string) {
+	s.mu.Lock()
+	sessions := make([]*Session, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+	s.mu.Unlock()
+	for _, session := range sessions {
+		session.agent.EndSession(reason)
+	}
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /sessions", s.handleCreateSession)
+	mux.HandleFunc("GET /sessions", s.handleListSessions)
+	mux.HandleFunc("GET /sessions/{id}", s.handleGetSession)
+	mux.HandleFunc("DELETE /sessions/{id}", s.handleDeleteSession)
+	mux.HandleFunc("POST /sessions/{id}/messages", s.handlePostMessage)
+	mux.HandleFunc("GET /sessions/{id}/messages", s.handleHistory)
+	mux.HandleFunc("GET /sessions/{id}/events", s.handleEvents)
+	mux.HandleFunc("POST /sessions/{id}/cancel", s.handleCancel)
+	mux.HandleFunc("GET /sessions/{id}/approvals", s.handleListApprovals)
+	mux.HandleFunc("POST /sessions/{id}/approvals/{callID}", s.handleResolveApproval)
+	return s.checkRequest(s.requireToken(mux))
+}
+
+// checkRequest refuses what a web page could send from the user's browser:
+// requests naming a host other than the server's own, as DNS rebinding
+// does, requests from another origin, and bodies other than JSON, which
+// forms and simple cross-origin requests send without a preflight.
+func (s *Server) checkRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.allowedHost(r.Host) {
+			writeError(w, http.StatusForbidden, fmt.Sprintf("host %q is not allowed", r.Host))
+			return
+		}
+		if origin := r.Header.Get("Origin"); origin != "" {
+			if u, err := url.Parse(origin); err != nil || !s.allowedHost(u.Host) {
+				writeError(w, http.StatusForbidden, fmt.Sprintf("origin %q is not allowed", origin))
+				return
+			}
+		}
+		// ContentLength is -1 for chunked bodies
+		if r.ContentLength != 0 {
+			if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+				writeError(w, http.StatusUnsupportedMediaType, "request body must be application/json")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowedHost reports whether host, with or without a port, is localhost,
+// a loopback address or the address the server listens on.
+func (s *Server) allowedHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	if strings.EqualFold(host, "localhost") || slices.Contains(s.hosts, host) {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (s *Server) requireToken(next http.Handler) http.Handler {
+	if s.token == "" {
+		return next
+	}
+	want := []byte("Bearer " + s.token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			writeError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+func newSessionID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func newServerToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (s *Server) session(w http.ResponseWriter, r *http.Request) *Session {
+	s.mu.Lock()
+	session, ok := s.sessions[r.PathValue("id")]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "session not found")
+		return nil
+	}
+	return session
+}
+
+type createSessionRequest struct {
+	Model string `json:"model,omitempty"`
+	Plan  *bool  `json:"plan,omitempty"`
+}
+
+func (s *Server) handleCreateSession(w http.ResponseWriter, r *http.Request) {
+	// The body is optional, and may be empty even when sent chunked
+	var req createSessionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+
+	session := &Session{
+		ID:          newSessionID(),
+		CreatedAt:   time.Now(),
+		subscribers: map[chan SessionEvent]struct{}{},
+		pending:     map[string]*pendingApproval{},
+	}
+	agent := NewAgent(s.client, nil, s.tools)
+	agent.applyConfig(s.cfg)
+	agent.lsp = s.lsp
+	agent.sessionID = session.ID
+	if req.Model != "" {
+		agent.model = req.Model
+	}
+	if req.Plan != nil {
+		agent.planMode = *req.Plan
+	}
+	agent.stream = true
+	agent.sink = session
+	agent.approver = session
+	session.agent = agent
+
+	s.mu.Lock()
+	s.sessions[session.ID] = session
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusCreated, session.summary())
+}
+
+func (s *Server) handleListSessions(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	summaries := make([]map[string]any, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		summaries = append(summaries, session.summary())
+	}
+	s.mu.Unlock()
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i]["created_at"].(time.Time).Before(summaries[j]["created_at"].(time.Time))
+	})
+	writeJSON(w, http.StatusOK, summaries)
+}
+
+func (s *Server) handleGetSession(w http.ResponseWriter, r *http.Request) {
+	if session := s.session(w, r); session != nil {
+		writeJSON(w, http.StatusOK, session.summary())
+	}
+}
+
+func (s *Server) handleDeleteSession(w http.ResponseWriter, r *http.Request) {
+	session := s.session(w, r)
+	if session == nil {
+		return
+	}
+	session.cancel()
+	s.mu.Lock()
+	delete(s.sessions, session.ID)
+	s.mu.Unlock()
+	session.agent.EndSession("deleted")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type postMessageRequest struct {
+	// Content is the user message. An empty message resumes a turn that
+	// ended with an error.
+	Content string `json:"content"`
+}
+
+func (s *Server) handlePostMessage(w http.ResponseWriter, r *http.Request) {
+	session := s.session(w, r)
+	if session == nil {
+		return
+	}
+	var req postMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+	if !session.startTurn(s.ctx, req.Content) {
+		writeError(w, http.StatusConflict, "a turn is already running in this session")
+		return
+	}
+	writeJSON(w, http.StatusAccepted, session.summary())
+}
+
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	if session := s.session(w, r); session != nil {
+		writeJSON(w, http.StatusOK, session.agent.History())
+	}
+}
+
+func (s *Server) handleCancel(w http.ResponseWriter, r *http.Request) {
+	if session := s.session(w, r); session != nil {
+		session.cancel()
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *Server) handleListApprovals(w http.ResponseWriter, r *http.Request) {
+	session := s.session(w, r)
+	if session == nil {
+		return
+	}
+	session.mu.Lock()
+	approvals := make([]*pendingApproval, 0, len(session.pending))
+	for _, approval := range session.pending {
+		approvals = append(approvals, approval)
+	}
+	session.mu.Unlock()
+	writeJSON(w, http.StatusOK, approvals)
+}
+
+type resolveApprovalRequest struct {
+	Approved bool `json:"approved"`
+}
+
+func (s *Server) handleResolveApproval(w http.ResponseWriter, r *http.Request) {
+	session := s.session(w, r)
+	if session == nil {
+		return
+	}
+	var req resolveApprovalRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+
+	callID := r.PathValue("callID")
+	session.mu.Lock()
+	approval, ok := session.pending[callID]
+	delete(session.pending, callID)
+	session.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "no pending approval for tool call "+callID)
+		return
+	}
+	approval.decision <- req.Approved
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleEvents streams session events as server-sent events until the
+// client disconnects.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	session := s.session(w, r)
+	if session == nil {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+
+	events := session.subscribe()
+	defer session.unsubscribe(events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case event, ok := <-events:
+			if !ok {
+				return // dropped for falling behind; the client reconnects
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+			flusher.Flush()
+		}
+	}
+}
+
+func (s *Session) summary() map[string]any {
+	s.mu.Lock()
+	busy := s.busy
+	pending := len(s.pending)
+	s.mu.Unlock()
+	return map[string]any{
+		"id":                s.ID,
+		"created_at":        s.CreatedAt,
+		"model":             s.agent.model,
+		"busy":              busy,
+		"pending_approvals": pending,
+		"message_count":     len(s.agent.History()),
+		"todos":             s.agent.Todos(),
+	}
+}
+
+func (s *Session) subscribe() chan SessionEvent {
+	ch := make(chan SessionEvent, 256)
+	s.mu.Lock()
+	s.subscribers[ch] = struct{}{}
+	s.mu.Unlock()
+	return ch
+}
+
+func (s *Session) unsubscribe(ch chan SessionEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.subscribers[ch]; ok {
+		delete(s.subscribers, ch)
+		close(ch)
+	}
+}
+
+// publish fans an event out to every subscriber. A subscriber whose buffer
+// is full is disconnected rather than allowed to stall the turn.
+func (s *Session) publish(eventType string, data any) {
+	event := SessionEvent{Type: eventType, Time: time.Now(), Data: data}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch := range s.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(s.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// startTurn appends content to the conversation and runs a turn in the
+// background. It reports false if a turn is already running.
+func (s *Session) startTurn(parent context.Context, content string) bool {
+	s.mu.Lock()
+	if s.busy {
+		s.mu.Unlock()
+		return false
+	}
+	ctx, cancel := context.WithCancel(parent)
+	s.busy = true
+	s.turnCtx = ctx
+	s.cancelTurn = cancel
+	s.mu.Unlock()
+
+	go func() {
+		defer cancel()
+		s.publish(EventTurnStarted, nil)
+		if s.appendPrompt(ctx, content) {
+			if err := s.agent.runTurn(ctx); err != nil {
+				s.Emit(ErrorEvent{Message: err.Error(), Resumable: true})
+			}
+		}
+
+		s.mu.Lock()
+		s.busy = false
+		s.turnCtx = nil
+		s.cancelTurn = nil
+		s.mu.Unlock()
+		s.publish(EventTurnFinished, nil)
+	}()
+	return true
+}
+
+// appendPrompt adds the user's message to the conversation, after the
+// prompt hooks, and reports whether the turn should run. An empty message
+// resumes the conversation as it is.
+func (s *Session) appendPrompt(ctx context.Context, content string) bool {
+	if content == "" {
+		return true
+	}
+	content, ok := s.agent.submitPrompt(ctx, content)
+	if !ok {
+		return false
+	}
+	message, attachments, err := s.agent.userMessage(content)
+	if err != nil {
+		s.Emit(ErrorEvent{Message: err.Error()})
+		return false
+	}
+	s.agent.appendMessage(message)
+	s.Emit(UserMessageEvent{Content: content, Attachments: attachments})
+	return true
+}
+
+func (s *Session) cancel() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cancelTurn != nil {
+		s.cancelTurn()
+	}
+}
+
+// Emit publishes the agent's events to the session's subscribers.
+func (s *Session) Emit(e Event) {
+	s.publish(e.EventType(), e)
+}
+
+// ContinueToolLoop stops the turn when a loop limit is hit; posting another
+// message continues or redirects it.
+func (s *Session) ContinueToolLoop(reason string) (loopDecision, string) {
+	s.publish(EventToolLoopPaused, map[string]string{"reason": reason})
+	return loopAbort, ""
+}
+
+// ApproveToolCall waits for POST /sessions/{id}/approvals/{callID}, or for
+// the turn to be cancelled.
+func (s *Session) ApproveToolCall(id, name string, input json.RawMessage) bool {
+	return s.awaitApproval(&pendingApproval{ID: id, Name: name, Input: input, decision: make(chan bool, 1)})
+}
+
+// ReviewFileChange waits for a decision on a file change the same way
+// ApproveToolCall does for tool calls.
+func (s *Session) ReviewFileChange(id string, change FileChange) bool {
+	return s.awaitApproval(&pendingApproval{ID: id, Name: "file_change", Change: &change, decision: make(chan bool, 1)})
+}
+
+// ApprovePlan waits for a decision on the plan, pending under the ID "plan".
+func (s *Session) ApprovePlan(plan string) bool {
+	return s.awaitApproval(&pendingApproval{ID: "plan", Name: "plan", Plan: plan, decision: make(chan bool, 1)})
+}
+
+func (s *Session) awaitApproval(approval *pendingApproval) bool {
+	id, name := approval.ID, approval.Name
+	s.mu.Lock()
+	ctx := s.turnCtx
+	s.pending[id] = approval
+	s.mu.Unlock()
+
+	s.publish(EventApprovalRequired, approval)
+
+	approved := false
+	select {
+	case approved = <-approval.decision:
+	case <-ctx.Done():
+		s.mu.Lock()
+		delete(s.pending, id)
+		s.mu.Unlock()
+	}
+	s.publish(EventApprovalResolved, map[string]any{"id": id, "name": name, "approved": approved})
+	return approved
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// sessionLogMu serializes writes to session logs, which sub-agents share
+// with their parent.
+var sessionLogMu sync.Mutex
+
+// defaultSessionLogDir returns ~/.local/state/agent/sessions, or the same
+// under $XDG_STATE_HOME when it is set.
+func defaultSessionLogDir() string {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "agent", "sessions")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".local", "state", "agent", "sessions")
+}
+
+// fileChangeRecord is the session log entry for a change a tool proposed.
+type fileChangeRecord struct {
+	ID     string `json:"id"`
+	Tool   string `json:"tool"`
+	Status string `json:"status"` // applied, rejected or failed
+	FileChange
+}
+
+// logFileChanges records what became of the changes of one tool call.
+func (a *Agent) logFileChanges(id, tool, status string, changes []FileChange) {
+	for _, change := range changes {
+		a.logSession("file_change", fileChangeRecord{ID: id, Tool: tool, Status: status, FileChange: change})
+	}
+}
+
+// logSession appends a record to the session's log, a JSON-lines file named
+// after the session in the session log directory. Nothing is logged when
+// the directory is not set.
+func (a *Agent) logSession(recordType string, record any) {
+	if a.sessionLogDir == "" {
+		return
+	}
+	fields := map[string]any{}
+	if data, err := json.Marshal(record); err == nil {
+		json.Unmarshal(data, &fields)
+	}
+	fields["type"] = recordType
+	fields["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	line, err := json.Marshal(fields)
+	if err != nil {
+		return
+	}
+
+	sessionLogMu.Lock()
+	defer sessionLogMu.Unlock()
+	err = os.MkdirAll(a.sessionLogDir, 0o700)
+	if err == nil {
+		var f *os.File
+		f, err = os.OpenFile(filepath.Join(a.sessionLogDir, a.sessionID+".jsonl"), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err == nil {
+			_, err = f.Write(append(line, '\n'))
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+		}
+	}
+	if err != nil {
+		a.emit(ErrorEvent{Message: "failed to write the session log: " + err.Error()})
+	}
+}
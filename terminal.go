@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// TerminalRenderer prints events as the ANSI-colored chat transcript.
+// Replies are rendered as Markdown when out is a terminal and printed as
+// plain text otherwise.
+type TerminalRenderer struct {
+	out       io.Writer
+	markdown  bool
+	streaming bool // a streamed reply is in progress
+	// prompt, if set, shows the input prompt through the line editor
+	// instead of printing it.
+	prompt func(string)
+}
+
+func NewTerminalRenderer(out io.Writer) *TerminalRenderer {
+	f, ok := out.(*os.File)
+	return &TerminalRenderer{
+		out:      out,
+		markdown: ok && term.IsTerminal(int(f.Fd())),
+	}
+}
+
+// width returns the terminal width, falling back to $COLUMNS and then 80.
+func (r *TerminalRenderer) width() int {
+	if f, ok := r.out.(*os.File); ok {
+		if w, _, err := term.GetSize(int(f.Fd())); err == nil && w > 0 {
+			return w
+		}
+	}
+	if w, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && w > 0 {
+		return w
+	}
+	return 80
+}
+
+func (r *TerminalRenderer) Emit(e Event) {
+	switch e := e.(type) {
+	case AwaitingInputEvent:
+		if r.prompt != nil {
+			r.prompt("\u001b[94mYou\u001b[0m: ")
+			return
+		}
+		fmt.Fprint(r.out, "\u001b[94mYou\u001b[0m: ")
+	case UserMessageEvent:
+		if len(e.Attachments) > 0 {
+			fmt.Fprintf(r.out, "\u001b[90mattached: %s\u001b[0m\n", strings.Join(e.Attachments, ", "))
+		}
+	case AssistantDeltaEvent:
+		if !r.streaming {
+			fmt.Fprint(r.out, "\u001b[93mGroq\u001b[0m: ")
+			r.streaming = true
+		}
+		fmt.Fprint(r.out, e.Content)
+	case AssistantMessageEvent:
+		if e.Streamed {
+			fmt.Fprintln(r.out)
+			r.streaming = false
+			return
+		}
+		if r.markdown {
+			fmt.Fprintf(r.out, "\u001b[93mGroq\u001b[0m:\n%s\n", renderMarkdown(e.Content, r.width()))
+			return
+		}
+		fmt.Fprintf(r.out, "\u001b[93mGroq\u001b[0m: %s\n", e.Content)
+	case ToolCallStartedEvent:
+		fmt.Fprintf(r.out, "\u001b[92mtool\u001b[0m: %s(%s)\n", e.Name, e.Input)
+	case ToolCallFinishedEvent:
+		if e.IsError {
+			message, _, _ := strings.Cut(e.Result, "\n")
+			fmt.Fprintf(r.out, "\u001b[91mtool\u001b[0m: %s: %s\n", e.Name, message)
+		}
+	case FileChangeEvent:
+		r.printDiff(e.Diff)
+	case TodoListEvent:
+		r.printTodos(e.Todos)
+	case ErrorEvent:
+		if r.streaming {
+			fmt.Fprintln(r.out)
+			r.streaming = false
+		}
+		fmt.Fprintf(r.out, "\u001b[91mError\u001b[0m: %s\n", e.Message)
+		if e.Resumable {
+			fmt.Fprintln(r.out, "Press enter to resume, or type a new message.")
+		}
+	}
+}
+
+// maxDiffLines caps how much of a diff is printed; the full diff is still
+// part of the event.
+const maxDiffLines = 200
+
+func (r *TerminalRenderer) printDiff(diff string) {
+	lines := strings.Split(strings.TrimSuffix(diff, "\n"), "\n")
+	for i, line := range lines {
+		if i == maxDiffLines {
+			fmt.Fprintf(r.out, "\u001b[90m... %d more lines\u001b[0m\n", len(lines)-i)
+			break
+		}
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"),
+			strings.HasPrefix(line, "rename "):
+			fmt.Fprintf(r.out, "\u001b[1m%s\u001b[0m\n", line)
+		case strings.HasPrefix(line, "@@"):
+			fmt.Fprintf(r.out, "\u001b[96m%s\u001b[0m\n", line)
+		case strings.HasPrefix(line, "+"):
+			fmt.Fprintf(r.out, "\u001b[92m%s\u001b[0m\n", line)
+		case strings.HasPrefix(line, "-"):
+			fmt.Fprintf(r.out, "\u001b[91m%s\u001b[0m\n", line)
+		default:
+			fmt.Fprintln(r.out, line)
+		}
+	}
+}
+
+func (r *TerminalRenderer) printTodos(todos []TodoItem) {
+	fmt.Fprintln(r.out, "\u001b[92mtodo\u001b[0m:")
+	for _, todo := range todos {
+		switch todo.Status {
+		case TodoCompleted:
+			fmt.Fprintf(r.out, "  \u001b[90m✔ %s\u001b[0m\n", todo.Content)
+		case TodoInProgress:
+			fmt.Fprintf(r.out, "  \u001b[93m▶ %s\u001b[0m\n", todo.Content)
+		default:
+			fmt.Fprintf(r.out, "  ☐ %s\n", todo.Content)
+		}
+	}
+}
+
+// TerminalApprover asks the user on the terminal, writing prompts to out
+// and reading answers with read.
+type TerminalApprover struct {
+	out  io.Writer
+	read func() (string, bool)
+}
+
+func NewTerminalApprover(out io.Writer, read func() (string, bool)) *TerminalApprover {
+	return &TerminalApprover{out: out, read: read}
+}
+
+func (t *TerminalApprover) ApproveToolCall(id, name string, input json.RawMessage) bool {
+	fmt.Fprintf(t.out, "\u001b[93mApprove\u001b[0m %s(%s)? [y/N] ", name, input)
+	answer, ok := t.read()
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return ok && (answer == "y" || answer == "yes")
+}
+
+// ReviewFileChange asks whether to apply a change whose diff the renderer
+// has just shown.
+func (t *TerminalApprover) ReviewFileChange(id string, change FileChange) bool {
+	fmt.Fprintf(t.out, "\u001b[93mApply\u001b[0m this change to %s? [y/N] ", change.Path)
+	answer, ok := t.read()
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return ok && (answer == "y" || answer == "yes")
+}
+
+// ApprovePlan asks whether to carry out the plan just printed. Declining
+// keeps plan mode on so the user can ask for changes to the plan.
+func (t *TerminalApprover) ApprovePlan(plan string) bool {
+	fmt.Fprint(t.out, "\u001b[93mApprove\u001b[0m this plan and start making changes? [y/N] ")
+	answer, ok := t.read()
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	if ok && (answer == "y" || answer == "yes") {
+		return true
+	}
+	fmt.Fprintln(t.out, "Still in plan mode. Tell the model what to change in the plan.")
+	return false
+}
+
+// ContinueToolLoop pauses the tool loop and lets the user continue,
+// redirect the model with a new message, or abort back to the prompt.
+func (t *TerminalApprover) ContinueToolLoop(reason string) (loopDecision, string) {
+	fmt.Fprintf(t.out, "\u001b[91mPaused\u001b[0m: %s.\n", reason)
+	fmt.Fprint(t.out, "Type 'c' to continue, 'a' to abort, or a message to redirect the model: ")
+
+	input, ok := t.read()
+	if !ok {
+		return loopAbort, ""
+	}
+
+	switch strings.ToLower(strings.TrimSpace(input)) {
+	case "c", "continue":
+		return loopContinue, ""
+	case "", "a", "abort":
+		return loopAbort, ""
+	default:
+		return loopRedirect, input
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// TodoStatus is the progress of one item on the task list.
+type TodoStatus string
+
+const (
+	TodoPending    TodoStatus = "pending"
+	TodoInProgress TodoStatus = "in_progress"
+	TodoCompleted  TodoStatus = "completed"
+)
+
+type TodoItem struct {
+	ID      string     `json:"id,omitempty" jsonschema_description:"Short stable identifier; assigned when empty"`
+	Content string     `json:"content" jsonschema:"required" jsonschema_description:"What needs to be done, as an imperative sentence"`
+	Status  TodoStatus `json:"status" jsonschema:"required,enum=pending,enum=in_progress,enum=completed" jsonschema_description:"pending, in_progress or completed"`
+}
+
+var TodoWriteDefinition = ToolDefinition{
+	Name: "todo_write",
+	Description: `Replace the task list for the current session. Use it for work with three or more steps: write the steps up front, mark one item in_progress before starting it, and mark it completed as soon as it is done.
+Always send the complete list; items left out are removed. At most one item may be in_progress.`,
+	InputSchema:   GenerateSchema[TodoWriteInput](),
+	ReadOnly:      true,
+	agentFunction: todoWrite,
+}
+
+type TodoWriteInput struct {
+	Todos []TodoItem `json:"todos" jsonschema:"required" jsonschema_description:"The complete, updated task list"`
+}
+
+var TodoReadDefinition = ToolDefinition{
+	Name:          "todo_read",
+	Description:   "Show the task list for the current session with the status of every item.",
+	InputSchema:   GenerateSchema[TodoReadInput](),
+	ReadOnly:      true,
+	agentFunction: todoRead,
+}
+
+type TodoReadInput struct{}
+
+func todoWrite(ctx context.Context, a *Agent, input json.RawMessage) (string, error) {
+	todoInput := TodoWriteInput{}
+	if err := json.Unmarshal(input, &todoInput); err != nil {
+		return "", err
+	}
+
+	todos := todoInput.Todos
+	inProgress := 0
+	for i := range todos {
+		todo := &todos[i]
+		todo.Content = strings.TrimSpace(todo.Content)
+		if todo.Content == "" {
+			return "", fmt.Errorf("todo %d has no content", i+1)
+		}
+		switch todo.Status {
+		case TodoPending, TodoCompleted:
+		case TodoInProgress:
+			inProgress++
+		default:
+			return "", fmt.Errorf("todo %d has invalid status %q (want pending, in_progress or completed)", i+1, todo.Status)
+		}
+		if todo.ID == "" {
+			todo.ID = strconv.Itoa(i + 1)
+		}
+	}
+	if inProgress > 1 {
+		return "", fmt.Errorf("%d items are in_progress; finish one before starting the next", inProgress)
+	}
+
+	a.mu.Lock()
+	a.todos = todos
+	a.mu.Unlock()
+	a.emit(TodoListEvent{Todos: slices.Clone(todos)})
+	// Sub-agents' lists are private to them, like the rest of their work
+	if _, subAgent := a.sink.(*subAgentSink); !subAgent {
+		a.logSession("todo_list", TodoListEvent{Todos: todos})
+	}
+
+	done := 0
+	for _, todo := range todos {
+		if todo.Status == TodoCompleted {
+			done++
+		}
+	}
+	return fmt.Sprintf("Task list updated (%d of %d completed):\n%s", done, len(todos), formatTodos(todos)), nil
+}
+
+func todoRead(ctx context.Context, a *Agent, input json.RawMessage) (string, error) {
+	todos := a.Todos()
+	if len(todos) == 0 {
+		return "The task list is empty.", nil
+	}
+	return formatTodos(todos), nil
+}
+
+// Todos returns a copy of the session's task list.
+func (a *Agent) Todos() []TodoItem {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return slices.Clone(a.todos)
+}
+
+func formatTodos(todos []TodoItem) string {
+	lines := make([]string, len(todos))
+	for i, todo := range todos {
+		mark := " "
+		switch todo.Status {
+		case TodoInProgress:
+			mark = ">"
+		case TodoCompleted:
+			mark = "x"
+		}
+		lines[i] = fmt.Sprintf("[%s] %s. %s", mark, todo.ID, todo.Content)
+	}
+	return strings.Join(lines, "\n")
+}
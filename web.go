@@ -0,0 +1,429 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+)
+
+// WebConfig controls the web tools.
+type WebConfig struct {
+	// AllowedDomains, when set, limits the web tools to these domains and
+	// their subdomains.
+	AllowedDomains []string `yaml:"allowed_domains,omitempty"`
+	// BlockedDomains are refused even when they are allowed.
+	BlockedDomains []string `yaml:"blocked_domains,omitempty"`
+	// CacheTTL is how long fetched pages are reused; zero disables the cache.
+	CacheTTL time.Duration `yaml:"cache_ttl"`
+	// Search configures web_search, which is off by default.
+	Search SearchConfig `yaml:"search,omitempty"`
+}
+
+func (c WebConfig) Validate() error {
+	var errs []error
+	for _, domain := range slices.Concat(c.AllowedDomains, c.BlockedDomains) {
+		if domain == "" || strings.ContainsAny(domain, "/:") {
+			errs = append(errs, fmt.Errorf("web: %q is not a domain name (write example.com, without a scheme or path)", domain))
+		}
+	}
+	if c.CacheTTL < 0 {
+		errs = append(errs, errors.New("web.cache_ttl cannot be negative"))
+	}
+	if err := c.Search.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+const (
+	maxWebFetchBytes   = 5 << 20 // response body read from the server
+	maxWebFetchChars   = 20000   // page text returned per call
+	maxWebCacheEntries = 50
+	maxWebRedirects    = 10
+	webUserAgent       = "agent/1.0 (web_fetch)"
+)
+
+// WebClient makes the requests of the web tools, enforcing the domain lists
+// on every request and redirect, and caches the pages it fetched. Loopback,
+// private and link-local addresses are refused wherever a name resolves,
+// so that pages cannot reach services on the user's machine or network,
+// unless the host is listed exactly in the allowed domains.
+type WebClient struct {
+	client   *http.Client
+	allowed  []string
+	blocked  []string
+	cacheTTL time.Duration
+
+	search        SearchProvider // nil when web_search is disabled
+	searchResults int
+
+	mu    sync.Mutex
+	cache map[string]webPage
+}
+
+// webPage is a fetched page converted to text.
+type webPage struct {
+	url       string // after redirects
+	title     string
+	content   string // Markdown for HTML, otherwise the text as served
+	truncated bool   // the body was larger than maxWebFetchBytes
+	fetched   time.Time
+}
+
+func NewWebClient(cfg WebConfig, timeout time.Duration) *WebClient {
+	w := &WebClient{
+		allowed:  normalizeDomains(cfg.AllowedDomains),
+		blocked:  normalizeDomains(cfg.BlockedDomains),
+		cacheTTL: cfg.CacheTTL,
+		cache:    map[string]webPage{},
+
+		search:        newSearchProvider(cfg.Search, timeout),
+		searchResults: cfg.Search.MaxResults,
+	}
+	if w.searchResults == 0 {
+		w.searchResults = defaultSearchResults
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = w.dial
+	w.client = &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxWebRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxWebRedirects)
+			}
+			return w.checkURL(req.URL)
+		},
+	}
+	return w
+}
+
+// dial connects to addr, refusing non-public addresses that names resolve
+// to unless the host is listed in the allowed domains or is a configured
+// proxy. Through a proxy, names are resolved by the proxy and only
+// addresses written in URLs are checked, by checkURL.
+func (w *WebClient) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if !slices.Contains(w.allowed, host) && !slices.Contains(proxyHosts(), host) {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			ip, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			return checkAddress(net.ParseIP(ip))
+		}
+	}
+	return dialer.DialContext(ctx, network, addr)
+}
+
+// checkAddress refuses loopback, private and link-local addresses.
+func checkAddress(ip net.IP) error {
+	kind := ""
+	switch {
+	case ip == nil:
+		return nil
+	case ip.IsLoopback():
+		kind = "loopback"
+	case ip.IsPrivate():
+		kind = "private"
+	case ip.IsLinkLocalUnicast(), ip.IsLinkLocalMulticast():
+		kind = "link-local"
+	case ip.IsUnspecified():
+		kind = "unspecified"
+	default:
+		return nil
+	}
+	return fmt.Errorf("%s is a %s address; list the host in web.allowed_domains to reach it", ip, kind)
+}
+
+// proxyHosts returns the hosts of the proxies set in the environment.
+func proxyHosts() []string {
+	var hosts []string
+	for _, name := range []string{"HTTP_PROXY", "http_proxy", "HTTPS_PROXY", "https_proxy"} {
+		proxy := os.Getenv(name)
+		if proxy == "" {
+			continue
+		}
+		if !strings.Contains(proxy, "://") {
+			proxy = "http://" + proxy
+		}
+		if u, err := url.Parse(proxy); err == nil && u.Hostname() != "" {
+			hosts = append(hosts, strings.ToLower(u.Hostname()))
+		}
+	}
+	return hosts
+}
+
+func normalizeDomains(domains []string) []string {
+	normalized := make([]string, len(domains))
+	for i, domain := range domains {
+		normalized[i] = strings.TrimPrefix(strings.ToLower(strings.TrimSuffix(domain, ".")), "*.")
+	}
+	return normalized
+}
+
+func (w *WebClient) toolDefinitions() []ToolDefinition {
+	tools := []ToolDefinition{{
+		Name: "web_fetch",
+		Description: `Fetch a web page, such as library documentation or an issue, and return its main content as Markdown. Plain text and JSON are returned as served.
+Long pages are returned in parts: the result says which offset to pass as start to read on, and lists the sections further down with their offsets.`,
+		InputSchema: GenerateSchema[WebFetchInput](),
+		Function:    w.webFetch,
+		ReadOnly:    true,
+	}}
+	if w.search != nil {
+		tools = append(tools, ToolDefinition{
+			Name:        "web_search",
+			Description: "Search the web, for example for an error message or how to use a library, and get back the titles, URLs and snippets of the top results. Read a result with web_fetch.",
+			InputSchema: GenerateSchema[WebSearchInput](),
+			Function:    w.webSearch,
+			ReadOnly:    true,
+		})
+	}
+	return tools
+}
+
+type WebFetchInput struct {
+	URL   string `json:"url" jsonschema:"required" jsonschema_description:"The http or https URL to fetch"`
+	Start int    `json:"start,omitempty" jsonschema_description:"Offset in the page text to start from, as given at the end of a previous result (default 0)"`
+}
+
+func (w *WebClient) webFetch(input json.RawMessage) (string, error) {
+	fetchInput := WebFetchInput{}
+	if err := json.Unmarshal(input, &fetchInput); err != nil {
+		return "", err
+	}
+	page, err := w.fetch(fetchInput.URL)
+	if err != nil {
+		return "", err
+	}
+	if fetchInput.Start < 0 || (fetchInput.Start > 0 && fetchInput.Start >= len(page.content)) {
+		return "", fmt.Errorf("start %d is outside the page text (%d characters)", fetchInput.Start, len(page.content))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "URL: %s\n", page.url)
+	if page.title != "" {
+		fmt.Fprintf(&b, "Title: %s\n", page.title)
+	}
+	b.WriteString("\n")
+	text, note := pageWindow(page.content, fetchInput.Start)
+	if strings.TrimSpace(text) == "" {
+		text = "(the page has no readable text; it may need JavaScript to render)"
+	}
+	b.WriteString(text)
+	if note != "" {
+		b.WriteString("\n\n" + note)
+	}
+	if page.truncated && note == "" {
+		fmt.Fprintf(&b, "\n\n[the page was cut off at %d MB]", maxWebFetchBytes>>20)
+	}
+	return b.String(), nil
+}
+
+// checkURL refuses URLs the agent may not fetch.
+func (w *WebClient) checkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported URL scheme %q (want http or https)", u.Scheme)
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "" {
+		return fmt.Errorf("URL %s has no host", u)
+	}
+	if matchesDomain(host, w.blocked) {
+		return fmt.Errorf("%s is blocked by web.blocked_domains", host)
+	}
+	if len(w.allowed) > 0 && !matchesDomain(host, w.allowed) {
+		return fmt.Errorf("%s is not in web.allowed_domains", host)
+	}
+	if !slices.Contains(w.allowed, host) {
+		return checkAddress(net.ParseIP(host))
+	}
+	return nil
+}
+
+func matchesDomain(host string, domains []string) bool {
+	for _, domain := range domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseWebURL parses a URL typed by the model, which may leave out the
+// scheme.
+func parseWebURL(raw string) (*url.URL, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, errors.New("url cannot be empty")
+	}
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+	u.Fragment = ""
+	return u, nil
+}
+
+// fetch returns the page at rawURL as text, from the cache when it was
+// fetched recently.
+func (w *WebClient) fetch(rawURL string) (webPage, error) {
+	u, err := parseWebURL(rawURL)
+	if err != nil {
+		return webPage{}, err
+	}
+	if err := w.checkURL(u); err != nil {
+		return webPage{}, err
+	}
+
+	key := u.String()
+	w.mu.Lock()
+	page, ok := w.cache[key]
+	w.mu.Unlock()
+	if ok && time.Since(page.fetched) < w.cacheTTL {
+		return page, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, key, nil)
+	if err != nil {
+		return webPage{}, err
+	}
+	req.Header.Set("User-Agent", webUserAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,text/markdown;q=0.9,text/plain;q=0.9,application/json;q=0.8,*/*;q=0.5")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return webPage{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return webPage{}, fmt.Errorf("GET %s: %s", resp.Request.URL, resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxWebFetchBytes+1))
+	if err != nil {
+		return webPage{}, fmt.Errorf("failed to read %s: %w", resp.Request.URL, err)
+	}
+	page = webPage{url: resp.Request.URL.String(), fetched: time.Now()}
+	if len(body) > maxWebFetchBytes {
+		body, page.truncated = body[:maxWebFetchBytes], true
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == "" {
+		mediaType, _, _ = mime.ParseMediaType(http.DetectContentType(body))
+	}
+	switch {
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+		page.content, page.title, err = htmlToMarkdown(strings.ToValidUTF8(string(body), "�"), resp.Request.URL)
+		if err != nil {
+			return webPage{}, fmt.Errorf("failed to parse %s: %w", page.url, err)
+		}
+	case isTextMediaType(mediaType):
+		page.content = strings.ToValidUTF8(string(body), "�")
+	default:
+		return webPage{}, fmt.Errorf("%s is %s; web_fetch reads HTML and text only", page.url, mediaType)
+	}
+
+	if w.cacheTTL > 0 {
+		w.store(key, page)
+	}
+	return page, nil
+}
+
+func isTextMediaType(mediaType string) bool {
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+json"), strings.HasSuffix(mediaType, "+xml"),
+		mediaType == "application/json", mediaType == "application/xml",
+		mediaType == "application/javascript", mediaType == "application/x-yaml",
+		mediaType == "application/yaml", mediaType == "application/toml":
+		return true
+	}
+	return false
+}
+
+// store caches page, dropping the oldest entry when the cache is full.
+func (w *WebClient) store(key string, page webPage) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.cache[key]; !ok && len(w.cache) >= maxWebCacheEntries {
+		oldest := ""
+		for k, p := range w.cache {
+			if oldest == "" || p.fetched.Before(w.cache[oldest].fetched) {
+				oldest = k
+			}
+		}
+		delete(w.cache, oldest)
+	}
+	w.cache[key] = page
+}
+
+// pageWindow returns up to maxWebFetchChars of content from start, cut at
+// a paragraph or line break when possible. When more follows, the note says
+// where to continue and lists the headings further down, so that the model
+// can jump to the section it needs.
+func pageWindow(content string, start int) (string, string) {
+	for start < len(content) && !utf8.RuneStart(content[start]) {
+		start++
+	}
+	rest := content[start:]
+	if len(rest) <= maxWebFetchChars {
+		return strings.TrimLeft(rest, "\n"), ""
+	}
+
+	// Prefer ending before a heading, then at a paragraph, then at a line
+	cut := maxWebFetchChars
+	if i := strings.LastIndex(rest[:cut], "\n\n#"); i > cut*3/4 {
+		cut = i + 1
+	} else if i := strings.LastIndex(rest[:cut], "\n\n"); i > cut/2 {
+		cut = i + 1
+	} else if i := strings.LastIndex(rest[:cut], "\n"); i > cut/2 {
+		cut = i + 1
+	} else {
+		for cut > 0 && !utf8.RuneStart(rest[cut]) {
+			cut--
+		}
+	}
+	end := start + cut
+
+	note := fmt.Sprintf("[Showing %d-%d of %d characters. Call web_fetch with start=%d to continue.", start, end, len(content), end)
+	var headings []string
+	inCode := false
+	offset := end
+	for _, line := range strings.SplitAfter(content[end:], "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			inCode = !inCode
+		}
+		if !inCode && strings.HasPrefix(line, "#") && len(headings) < 20 {
+			headings = append(headings, fmt.Sprintf("%s (start=%d)", trimmed, offset))
+		}
+		offset += len(line)
+	}
+	if len(headings) > 0 {
+		note += " Sections further down:\n" + strings.Join(headings, "\n")
+	}
+	return strings.TrimLeft(rest[:cut], "\n"), note + "]"
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newLocalWebClient returns a WebClient that may reach the test servers,
+// which listen on a loopback address.
+func newLocalWebClient(cfg WebConfig) *WebClient {
+	cfg.AllowedDomains = append(cfg.AllowedDomains, "127.0.0.1")
+	return NewWebClient(cfg, 5*time.Second)
+}
+
+func fetchInput(t *testing.T, rawURL string, start int) json.RawMessage {
+	t.Helper()
+	input, err := json.Marshal(WebFetchInput{URL: rawURL, Start: start})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return input
+}
+
+const testPage = `<!DOCTYPE html>
+<html>
+<head><title>Widget docs</title><style>body { color: red }</style></head>
+<body>
+<nav><a href="/">Home</a> <a href="/blog">Blog</a></nav>
+<main>
+<h1>Widgets</h1>
+<p>Widgets are <strong>small</strong> and <em>useful</em>. See the <a href="/guide#install">install guide</a>.</p>
+<ul><li>Fast</li><li>Cheap<ul><li>Very cheap</li></ul></li></ul>
+<ol><li>Download</li><li>Run</li></ol>
+<pre><code class="language-go">func main() {
+	fmt.Println("hi")
+}</code></pre>
+<table>
+<tr><th>Name</th><th>Size</th></tr>
+<tr><td>small</td><td>1</td></tr>
+</table>
+<p hidden>Secret text</p>
+<script>alert("x")</script>
+</main>
+<footer>Copyright</footer>
+</body>
+</html>`
+
+func TestWebFetchConvertsHTML(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, testPage)
+	}))
+	defer server.Close()
+
+	web := newLocalWebClient(WebConfig{})
+	out, err := web.webFetch(fetchInput(t, server.URL+"/docs", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"URL: " + server.URL + "/docs\n",
+		"Title: Widget docs\n",
+		"# Widgets",
+		"Widgets are **small** and *useful*. See the [install guide](" + server.URL + "/guide#install).",
+		"- Fast\n- Cheap\n",
+		"  - Very cheap",
+		"1. Download\n2. Run",
+		"```go\nfunc main() {\n\tfmt.Println(\"hi\")\n}\n```",
+		"| Name | Size |\n| --- | --- |\n| small | 1 |",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output is missing %q:\n%s", want, out)
+		}
+	}
+	for _, unwanted := range []string{"Blog", "Copyright", "Secret text", "alert", "color: red"} {
+		if strings.Contains(out, unwanted) {
+			t.Errorf("output contains %q:\n%s", unwanted, out)
+		}
+	}
+}
+
+func TestWebFetchDomainLists(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "reached")
+	}))
+	defer target.Close()
+	targetURL, _ := url.Parse(target.URL)
+
+	// The redirect goes to the same server under another name, so that it
+	// can be blocked separately
+	redirector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://localhost:"+targetURL.Port()+"/", http.StatusFound)
+	}))
+	defer redirector.Close()
+
+	tests := []struct {
+		name    string
+		cfg     WebConfig
+		url     string
+		wantErr string
+	}{
+		{"loopback address", WebConfig{}, target.URL, "127.0.0.1 is a loopback address"},
+		{"name resolving to a loopback address", WebConfig{}, "http://localhost:" + targetURL.Port(), "is a loopback address"},
+		{"blocked", WebConfig{BlockedDomains: []string{"127.0.0.1"}}, target.URL, "blocked by web.blocked_domains"},
+		{"not allowed", WebConfig{AllowedDomains: []string{"example.com"}}, target.URL, "not in web.allowed_domains"},
+		{"allowed", WebConfig{AllowedDomains: []string{"127.0.0.1"}}, target.URL, ""},
+		{"allowed by name", WebConfig{AllowedDomains: []string{"localhost"}}, "http://localhost:" + targetURL.Port(), ""},
+		{"redirect to a blocked host", WebConfig{AllowedDomains: []string{"127.0.0.1"}, BlockedDomains: []string{"localhost"}}, redirector.URL, "localhost is blocked"},
+		{"redirect to a host that is not allowed", WebConfig{AllowedDomains: []string{"127.0.0.1"}}, redirector.URL, "localhost is not in web.allowed_domains"},
+		{"unsupported scheme", WebConfig{}, "ftp://127.0.0.1/file", "unsupported URL scheme"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			web := NewWebClient(tt.cfg, 5*time.Second)
+			out, err := web.webFetch(fetchInput(t, tt.url, 0))
+			if tt.wantErr == "" {
+				if err != nil || !strings.Contains(out, "reached") {
+					t.Errorf("got %q, %v; want the page", out, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v; want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckAddress(t *testing.T) {
+	tests := map[string]string{
+		"127.0.0.1":        "loopback",
+		"::1":              "loopback",
+		"::ffff:127.0.0.1": "loopback",
+		"10.1.2.3":         "private",
+		"192.168.0.1":      "private",
+		"fd00::1":          "private",
+		"169.254.169.254":  "link-local",
+		"fe80::1":          "link-local",
+		"0.0.0.0":          "unspecified",
+		"93.184.215.14":    "",
+		"2606:4700::1111":  "",
+	}
+	for address, kind := range tests {
+		err := checkAddress(net.ParseIP(address))
+		if kind == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", address, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), "a "+kind+" address") {
+			t.Errorf("%s: got error %v, want a %s address", address, err, kind)
+		}
+	}
+}
+
+func TestMatchesDomain(t *testing.T) {
+	domains := normalizeDomains([]string{"Example.com", "*.docs.org."})
+	tests := map[string]bool{
+		"example.com":     true,
+		"api.example.com": true,
+		"badexample.com":  false,
+		"docs.org":        true,
+		"go.docs.org":     true,
+		"example.org":     false,
+	}
+	for host, want := range tests {
+		if got := matchesDomain(host, domains); got != want {
+			t.Errorf("matchesDomain(%q) = %v, want %v", host, got, want)
+		}
+	}
+}
+
+func TestWebFetchCache(t *testing.T) {
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintf(w, "visit %d", hits.Load())
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		ttl      time.Duration
+		wantHits int32
+	}{
+		{"reused within the TTL", time.Minute, 1},
+		{"disabled", 0, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hits.Store(0)
+			web := newLocalWebClient(WebConfig{CacheTTL: tt.ttl})
+			for range 2 {
+				if _, err := web.webFetch(fetchInput(t, server.URL, 0)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := hits.Load(); got != tt.wantHits {
+				t.Errorf("server was hit %d times, want %d", got, tt.wantHits)
+			}
+		})
+	}
+
+	t.Run("expired", func(t *testing.T) {
+		hits.Store(0)
+		web := newLocalWebClient(WebConfig{CacheTTL: time.Minute})
+		if _, err := web.webFetch(fetchInput(t, server.URL, 0)); err != nil {
+			t.Fatal(err)
+		}
+		for key, page := range web.cache {
+			page.fetched = page.fetched.Add(-2 * time.Minute)
+			web.cache[key] = page
+		}
+		out, err := web.webFetch(fetchInput(t, server.URL, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out, "visit 2") {
+			t.Errorf("expired page was reused:\n%s", out)
+		}
+	})
+}
+
+func TestWebFetchWindows(t *testing.T) {
+	var page strings.Builder
+	page.WriteString("<html><body><main>")
+	for section := 1; section <= 6; section++ {
+		fmt.Fprintf(&page, "<h2>Section %d</h2>", section)
+		for range 20 {
+			fmt.Fprintf(&page, "<p>%s</p>", strings.Repeat("words ", 40))
+		}
+	}
+	page.WriteString("</main></body></html>")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, page.String())
+	}))
+	defer server.Close()
+
+	web := newLocalWebClient(WebConfig{CacheTTL: time.Minute})
+	start, seen := 0, 0
+	for part := 1; ; part++ {
+		out, err := web.webFetch(fetchInput(t, server.URL, start))
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen += strings.Count(out, "## Section")
+		_, note, ok := strings.Cut(out, "[Showing ")
+		if !ok {
+			break
+		}
+		if part > 10 {
+			t.Fatal("the page never ended")
+		}
+		if part == 1 && !strings.Contains(note, "Sections further down:") {
+			t.Errorf("part %d does not list the later sections:\n%s", part, note)
+		}
+		if _, err := fmt.Sscanf(note[strings.Index(note, "start=")+len("start="):], "%d", &start); err != nil {
+			t.Fatalf("part %d has no start offset: %v", part, err)
+		}
+		body := out[strings.Index(out, "\n\n")+2 : strings.Index(out, "[Showing ")]
+		if len(body) > maxWebFetchChars {
+			t.Errorf("part %d has %d characters, more than %d", part, len(body), maxWebFetchChars)
+		}
+	}
+	if seen < 6 {
+		t.Errorf("saw %d section headings in the text of all parts, want at least 6", seen)
+	}
+
+	if _, err := web.webFetch(fetchInput(t, server.URL, 10_000_000)); err == nil {
+		t.Error("a start past the end of the page was accepted")
+	}
+}
+
+func TestWebFetchContentTypes(t *testing.T) {
+	bodies := map[string]string{
+		"/data.json": `{"ok": true}`,
+		"/notes.md":  "# Notes",
+		"/doc.pdf":   "%PDF-1.4",
+		"/image.png": "\x89PNG\r\n\x1a\n",
+	}
+	types := map[string]string{
+		"/data.json": "application/json",
+		"/notes.md":  "text/markdown",
+		"/doc.pdf":   "application/pdf",
+		"/image.png": "image/png",
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", types[r.URL.Path])
+		fmt.Fprint(w, bodies[r.URL.Path])
+	}))
+	defer server.Close()
+
+	web := newLocalWebClient(WebConfig{})
+	for path, body := range bodies {
+		out, err := web.webFetch(fetchInput(t, server.URL+path, 0))
+		switch types[path] {
+		case "application/pdf", "image/png":
+			if err == nil || !strings.Contains(err.Error(), "HTML and text only") {
+				t.Errorf("%s: got %q, %v; want it refused", path, out, err)
+			}
+		default:
+			if err != nil || !strings.Contains(out, body) {
+				t.Errorf("%s: got %q, %v; want the body as served", path, out, err)
+			}
+		}
+	}
+}